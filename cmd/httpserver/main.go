@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"fmt"
 	"io"
@@ -9,8 +10,8 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
-	"strings"
 	"syscall"
+	"time"
 
 	"github.com/iahta/httpfromtcp/internal/headers"
 	"github.com/iahta/httpfromtcp/internal/request"
@@ -19,85 +20,86 @@ import (
 )
 
 const port = 42069
+const shutdownTimeout = 10 * time.Second
 
 func main() {
-	server, err := server.Serve(port, handler)
+	metrics := server.NewMetrics()
+	handler := server.Chain(
+		newRouter(metrics).Dispatch,
+		server.Recover(nil, nil),
+		server.RequestID(),
+	)
+	server, err := server.ServeWithConfig(port, handler, server.ServerConfig{
+		AccessLog: server.NewAccessLog(os.Stdout, server.AccessLogCombined),
+		Metrics:   metrics,
+	})
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
-	defer server.Close()
 	log.Println("Server started on port", port)
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
-	log.Println("Server gracefully stopped")
-}
-
-func handler(w *response.Writer, req *request.Request) {
-	if req.RequestLine.RequestTarget == "/yourproblem" {
-		handler400(w, req)
-		return
-	}
-
-	if req.RequestLine.RequestTarget == "/myproblem" {
-		handler500(w, req)
-	}
-
-	if strings.HasPrefix(req.RequestLine.RequestTarget, "/video") {
-		videoHandler(w, req)
-	}
 
-	if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/") {
-		target := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin/")
-		route := fmt.Sprintf("https://httpbin.org/%s", target)
-		req.RequestLine.RequestTarget = route
-		httpHandler(w, req)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Server forced to stop: %v", err)
 		return
-
 	}
+	log.Println("Server gracefully stopped")
+}
 
-	handler200(w, req)
-	return
+func newRouter(metrics *server.Metrics) *server.Router {
+	router := server.NewRouter()
+	router.Get("/metrics", metrics.Handler())
+	router.Get("/yourproblem", server.HandleErrors(handler400))
+	router.Get("/myproblem", server.HandleErrors(handler500))
+	router.Get("/video", server.HandleErrors(videoHandler))
+	router.Get("/httpbin/*", server.HandleErrors(httpHandler))
+	router.NotFound = handler200
+	return router
 }
 
-func videoHandler(w *response.Writer, req *request.Request) {
+func videoHandler(w *response.Writer, req *request.Request) error {
 	data, err := os.ReadFile("./assets/vim.mp4")
 	if err != nil {
-		fmt.Printf("error reading file: %v", err)
-		handler500(w, req)
-		return
+		return fmt.Errorf("error reading file: %v", err)
 	}
 	w.WriteStatusLine(response.StatusOK)
 	h := response.GetDefaultHeaders(len(data))
 	h.Override("Content-Type", "video/mp4")
 	err = w.WriteHeaders(h)
 	if err != nil {
-		fmt.Printf("error writing headers: %v", err)
-		handler500(w, req)
-		return
+		return fmt.Errorf("error writing headers: %v", err)
 	}
 	_, err = w.WriteBody(data)
 	if err != nil {
-		fmt.Printf("error writing body: %v", err)
-		handler500(w, req)
-		return
+		return fmt.Errorf("error writing body: %v", err)
 	}
+	return nil
 }
 
-func httpHandler(w *response.Writer, req *request.Request) {
+func httpHandler(w *response.Writer, req *request.Request) error {
 	buf := make([]byte, 32, 32)
-	resp, err := http.Get(req.RequestLine.RequestTarget)
+	route := fmt.Sprintf("https://httpbin.org/%s", req.PathParam("*"))
+	if query := req.RequestLine.Target.RawQuery; query != "" {
+		route += "?" + query
+	}
+	resp, err := http.Get(route)
 	if err != nil {
-		handler400(w, req)
-		return
+		return &server.HandlerError{
+			StatusCode: response.StatusBadRequest,
+			Message:    fmt.Sprintf("unable to reach httpbin: %v", err),
+		}
 	}
 	defer resp.Body.Close()
 	w.WriteStatusLine(response.StatusOK)
 	h := response.GetDefaultHeaders(0)
 	h.OverrideContentLength()
-	h.Set("Trailer", "X-Content-SHA256")
-	h.Set("Trailer", "X-Content-Length")
+	h.Add("Trailer", "X-Content-SHA256")
+	h.Add("Trailer", "X-Content-Length")
 	w.WriteHeaders(h)
 
 	body := []byte{}
@@ -111,60 +113,30 @@ func httpHandler(w *response.Writer, req *request.Request) {
 			break
 		}
 		if err != nil {
-			handler500(w, req)
-			return
+			return fmt.Errorf("error reading httpbin response: %v", err)
 		}
-		fmt.Printf("%v\n", n)
 	}
 	w.WriteChunkedBodyDone()
 	sum := sha256.Sum256(body)
 	t := headers.NewHeaders()
 	bodyLen := strconv.Itoa(len(body))
-	t.Set("X-Content-SHA256", fmt.Sprintf("%x", sum))
-	t.Set("X-Content-Length", bodyLen)
-	err = w.WriteTrailers(t)
-	if err != nil {
-		handler500(w, req)
-		return
-	}
+	t.Add("X-Content-SHA256", fmt.Sprintf("%x", sum))
+	t.Add("X-Content-Length", bodyLen)
+	return w.WriteTrailers(t)
 }
 
-func handler400(w *response.Writer, _ *request.Request) {
-	w.WriteStatusLine(response.StatusBadRequest)
-	body := []byte(`<html>
-	<head>
-	<title>400 Bad Request</title>
-	</head>
-	<body>
-	<h1>Bad Request</h1>
-	<p>Your request honestly kinda sucked.</p>
-	</body>
-	</html>
-	`)
-	h := response.GetDefaultHeaders(len(body))
-	h.Override("Content-Type", "text/html")
-	w.WriteHeaders(h)
-	w.WriteBody(body)
-	return
+func handler400(_ *response.Writer, _ *request.Request) error {
+	return &server.HandlerError{
+		StatusCode: response.StatusBadRequest,
+		Message:    "Your request honestly kinda sucked.",
+	}
 }
 
-func handler500(w *response.Writer, _ *request.Request) {
-	w.WriteStatusLine(response.StatusInternalServerError)
-	body := []byte(`<html>
-	<head>
-	<title>500 Internal Server Error</title>
-	</head>
-	<body>
-	<h1>500 Internal Server Error</h1>
-	<p>Okay, you know what? This one is on me.</p>
-	</body>
-	</html>
-	`)
-	h := response.GetDefaultHeaders(len(body))
-	h.Override("Content-Type", "text/html")
-	w.WriteHeaders(h)
-	w.WriteBody(body)
-	return
+func handler500(_ *response.Writer, _ *request.Request) error {
+	return &server.HandlerError{
+		StatusCode: response.StatusInternalServerError,
+		Message:    "Okay, you know what? This one is on me.",
+	}
 }
 
 func handler200(w *response.Writer, _ *request.Request) {
@@ -37,8 +37,10 @@ func main() {
 			channelLines.RequestLine.Method, channelLines.RequestLine.RequestTarget,
 			channelLines.RequestLine.HttpVersion)
 		fmt.Printf("Headers:\n")
-		for key, value := range channelLines.Headers {
-			fmt.Printf("- %s: %s\n", key, value)
+		for key, values := range channelLines.Headers.All() {
+			for _, value := range values {
+				fmt.Printf("- %s: %s\n", key, value)
+			}
 		}
 		fmt.Printf("Body:\n")
 		fmt.Printf("%s", string(channelLines.Body))
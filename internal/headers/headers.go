@@ -3,19 +3,55 @@ package headers
 import (
 	"bytes"
 	"fmt"
+	"iter"
 	"slices"
 	"strings"
 )
 
 const crlf = "\r\n"
 
-type Headers map[string]string
+// Headers is an ordered list of header fields. Each field keeps the casing
+// it was first added or parsed with and all of its values in order, so
+// fields that cannot be comma-joined, like Set-Cookie, survive a round trip.
+// Lookups are case-insensitive.
+type Headers struct {
+	fields []field
+	// lastParsed is one more than the index of the field the last parsed
+	// line added a value to, or 0 before the first line. An obs-fold
+	// continuation belongs to that value, which need not be the last field
+	// since a repeated name is merged into its first occurrence.
+	lastParsed int
+}
+
+type field struct {
+	name   string
+	values []string
+}
+
+func NewHeaders() *Headers {
+	return &Headers{}
+}
+
+// ObsFoldPolicy decides what Parse does with obsolete line folding, a field
+// line starting with whitespace that continues the previous field's value.
+type ObsFoldPolicy int
+
+const (
+	// ObsFoldReject fails the parse, as RFC 9112 section 5.2 allows.
+	ObsFoldReject ObsFoldPolicy = iota
+	// ObsFoldUnfold joins the continuation onto the previous value with a
+	// single space.
+	ObsFoldUnfold
+)
 
-func NewHeaders() Headers {
-	return map[string]string{}
+// Parse parses one field line from data, rejecting obsolete line folding.
+func (h *Headers) Parse(data []byte) (n int, done bool, err error) {
+	return h.ParseWithPolicy(data, ObsFoldReject)
 }
 
-func (h Headers) Parse(data []byte) (n int, done bool, err error) {
+// ParseWithPolicy parses one field line from data, handling obsolete line
+// folding according to policy.
+func (h *Headers) ParseWithPolicy(data []byte, policy ObsFoldPolicy) (n int, done bool, err error) {
 	idx := bytes.Index(data, []byte(crlf))
 	if idx == -1 {
 		return 0, false, nil
@@ -23,48 +59,117 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 	if idx == 0 {
 		return 2, true, nil
 	}
+	line := data[:idx]
 
-	headerParts := bytes.SplitN(data[:idx], []byte(":"), 2)
-	key := strings.ToLower(string(headerParts[0]))
+	if isWhitespace(line[0]) {
+		// With nothing to continue, a leading space could make a field
+		// invisible to one parser and not another, so it is always an error.
+		if h.lastParsed == 0 {
+			return 0, false, fmt.Errorf("first field line starts with whitespace: %q", line)
+		}
+		if policy != ObsFoldUnfold {
+			return 0, false, fmt.Errorf("obsolete line folding not allowed: %q", line)
+		}
+		value := bytes.Trim(line, " \t")
+		if !validValue(value) {
+			return 0, false, fmt.Errorf("invalid header value: %q", value)
+		}
+		last := &h.fields[h.lastParsed-1]
+		prev := last.values[len(last.values)-1]
+		if len(value) > 0 {
+			if prev != "" {
+				prev += " "
+			}
+			last.values[len(last.values)-1] = prev + string(value)
+		}
+		return idx + 2, false, nil
+	}
+
+	headerParts := bytes.SplitN(line, []byte(":"), 2)
+	if len(headerParts) != 2 {
+		return 0, false, fmt.Errorf("missing colon in header line: %q", line)
+	}
+	key := string(headerParts[0])
 
 	if key != strings.TrimRight(key, " ") {
 		return 0, false, fmt.Errorf("invalid header name: %s", key)
 	}
 
-	value := bytes.TrimSpace(headerParts[1])
-	key = strings.TrimSpace(key)
+	value := bytes.Trim(headerParts[1], " \t")
+	if key == "" {
+		return 0, false, fmt.Errorf("empty header name: %q", line)
+	}
 	if !validTokens([]byte(key)) {
 		return 0, false, fmt.Errorf("invalid header token found: %s", key)
 	}
-	h.Set(key, string(value))
+	if !validValue(value) {
+		return 0, false, fmt.Errorf("invalid header value for %s: %q", key, value)
+	}
+	h.Add(key, string(value))
+	h.lastParsed = h.find(key) + 1
 	return idx + 2, false, nil
 }
 
+func isWhitespace(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+// validValue reports whether value is a valid field-value per RFC 9110
+// section 5.5: visible ASCII, obs-text, spaces and tabs, with no CR, LF, NUL
+// or other control characters.
+func validValue(value []byte) bool {
+	for _, c := range value {
+		if c < ' ' && c != '\t' || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
 var tokenChars = []byte{'!', '#', '$', '%', '&', '\'', '*', '+', '-', '.', '^', '_', '`', '|', '~'}
 
-func (h Headers) Set(key, value string) {
-	key = strings.ToLower(key)
-	if v, ok := h[key]; ok {
-		value = strings.Join([]string{
-			v,
-			value,
-		}, ", ")
+func (h *Headers) find(key string) int {
+	if h == nil {
+		return -1
+	}
+	return slices.IndexFunc(h.fields, func(f field) bool {
+		return strings.EqualFold(f.name, key)
+	})
+}
+
+// Add appends value to the values of key, adding the field at the end if it
+// is not present yet.
+func (h *Headers) Add(key, value string) {
+	if i := h.find(key); i != -1 {
+		h.fields[i].values = append(h.fields[i].values, value)
+		return
 	}
-	h[key] = value
+	h.fields = append(h.fields, field{name: key, values: []string{value}})
 }
 
-func (h Headers) Override(key, value string) {
-	key = strings.ToLower(key)
-	h[key] = value
+// Override replaces all values of key with value, keeping the field's
+// position if it is already present.
+func (h *Headers) Override(key, value string) {
+	if i := h.find(key); i != -1 {
+		h.fields[i] = field{name: key, values: []string{value}}
+		return
+	}
+	h.fields = append(h.fields, field{name: key, values: []string{value}})
 }
 
-func (h Headers) OverrideContentLength() {
-	delete(h, strings.ToLower("Content-Length"))
-	h[strings.ToLower("Transfer-Encoding")] = "chunked"
+func (h *Headers) Del(key string) {
+	if i := h.find(key); i != -1 {
+		h.fields = slices.Delete(h.fields, i, i+1)
+	}
 }
 
-func (h Headers) AnnounceTrailer() {
-	h[strings.ToLower("Trailer")] = "X-Content-Sha256, X-Content-Length"
+func (h *Headers) OverrideContentLength() {
+	h.Del("Content-Length")
+	h.Override("Transfer-Encoding", "chunked")
+}
+
+func (h *Headers) AnnounceTrailer() {
+	h.Override("Trailer", "X-Content-Sha256, X-Content-Length")
 }
 
 func validTokens(data []byte) bool {
@@ -86,7 +191,84 @@ func isTokenChar(c byte) bool {
 
 }
 
-func (h Headers) Get(key string) (string, bool) {
-	v, ok := h[strings.ToLower(key)]
-	return v, ok
+// Get returns the values of key combined into one comma-separated list, as
+// RFC 9110 allows for repeated fields. Use Values for fields like Set-Cookie
+// whose values cannot be combined.
+func (h *Headers) Get(key string) (string, bool) {
+	i := h.find(key)
+	if i == -1 {
+		return "", false
+	}
+	return strings.Join(h.fields[i].values, ", "), true
+}
+
+// Values returns every value of key in the order they were added.
+func (h *Headers) Values(key string) []string {
+	i := h.find(key)
+	if i == -1 {
+		return nil
+	}
+	return h.fields[i].values
+}
+
+// Len returns the number of distinct fields.
+func (h *Headers) Len() int {
+	if h == nil {
+		return 0
+	}
+	return len(h.fields)
+}
+
+// All iterates over the fields in order, yielding each name with its
+// original casing along with all of its values.
+func (h *Headers) All() iter.Seq2[string, []string] {
+	return func(yield func(string, []string) bool) {
+		if h == nil {
+			return
+		}
+		for _, f := range h.fields {
+			if !yield(f.name, f.values) {
+				return
+			}
+		}
+	}
+}
+
+// HasToken reports whether the comma-separated list in header key contains
+// token, compared case-insensitively, as used by Connection and
+// Transfer-Encoding.
+func (h *Headers) HasToken(key, token string) bool {
+	for _, v := range h.Values(key) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CanonicalName returns name with the first letter and every letter after a
+// hyphen upper-cased and the rest lower-cased, e.g. "content-type" becomes
+// "Content-Type". Names with characters other than letters, digits and
+// hyphens are returned unchanged.
+func CanonicalName(name string) string {
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			return name
+		}
+	}
+	b := []byte(name)
+	upper := true
+	for i, c := range b {
+		switch {
+		case upper && c >= 'a' && c <= 'z':
+			b[i] = c - ('a' - 'A')
+		case !upper && c >= 'A' && c <= 'Z':
+			b[i] = c + ('a' - 'A')
+		}
+		upper = c == '-'
+	}
+	return string(b)
 }
@@ -14,7 +14,7 @@ func TestRequestLineParse(t *testing.T) {
 	n, done, err := headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, []string{"localhost:42069"}, headers.Values("host"))
 	assert.Equal(t, 23, n)
 	assert.False(t, done)
 
@@ -26,14 +26,22 @@ func TestRequestLineParse(t *testing.T) {
 	assert.Equal(t, 0, n)
 	assert.False(t, done)
 
-	// Test: Valid single header with extra whitespace
+	// Test: Leading whitespace on the first line
 	headers = NewHeaders()
 	data = []byte("    Host:   localhost:42069   \r\n\r\n")
 	n, done, err = headers.Parse(data)
+	require.Error(t, err)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+
+	// Test: Valid single header with extra whitespace
+	headers = NewHeaders()
+	data = []byte("Host:   localhost:42069   \r\n\r\n")
+	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
-	assert.Equal(t, 32, n)
+	assert.Equal(t, []string{"localhost:42069"}, headers.Values("host"))
+	assert.Equal(t, 28, n)
 	assert.False(t, done)
 	// Test: Valid 2 headers with existing headers
 	headers = NewHeaders()
@@ -45,8 +53,8 @@ func TestRequestLineParse(t *testing.T) {
 	assert.False(t, done)
 	num, done2, err2 := headers.Parse(data[n:])
 	require.NoError(t, err2)
-	assert.Equal(t, "application/json", headers["content-type"])
-	assert.Equal(t, "*/*", headers["accept"])
+	assert.Equal(t, []string{"application/json"}, headers.Values("content-type"))
+	assert.Equal(t, []string{"*/*"}, headers.Values("accept"))
 	assert.Equal(t, 13, num)
 	assert.False(t, done2)
 	close := n + num
@@ -66,18 +74,138 @@ func TestRequestLineParse(t *testing.T) {
 	_, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	assert.False(t, done)
-	assert.Equal(t, "localhost:42069", headers["h$st"])
+	assert.Equal(t, []string{"localhost:42069"}, headers.Values("h$st"))
 	// Test: Multiple Values
 	headers = NewHeaders()
 	data = []byte("Accept: application\r\nAccept: */*\r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "application", headers["accept"])
+	assert.Equal(t, []string{"application"}, headers.Values("accept"))
 	assert.Equal(t, 21, n)
 	assert.False(t, done)
 	_, done2, err2 = headers.Parse(data[n:])
 	require.NoError(t, err2)
 	assert.False(t, done2)
-	assert.Equal(t, "application, */*", headers["accept"])
+	assert.Equal(t, []string{"application", "*/*"}, headers.Values("accept"))
+}
+
+func TestHeadersMultiValue(t *testing.T) {
+	// Test: Order and original casing are preserved
+	headers := NewHeaders()
+	data := []byte("Set-Cookie: a=1\r\nX-Custom-ID: 7\r\nset-cookie: b=2; Path=/\r\n\r\n")
+	n, _, err := headers.Parse(data)
+	require.NoError(t, err)
+	m, _, err := headers.Parse(data[n:])
+	require.NoError(t, err)
+	_, _, err = headers.Parse(data[n+m:])
+	require.NoError(t, err)
+	names := []string{}
+	for name := range headers.All() {
+		names = append(names, name)
+	}
+	assert.Equal(t, []string{"Set-Cookie", "X-Custom-ID"}, names)
+	assert.Equal(t, []string{"a=1", "b=2; Path=/"}, headers.Values("SET-COOKIE"))
+
+	// Test: Get combines values, lookups ignore case
+	v, ok := headers.Get("set-cookie")
+	assert.True(t, ok)
+	assert.Equal(t, "a=1, b=2; Path=/", v)
+	v, ok = headers.Get("x-custom-id")
+	assert.True(t, ok)
+	assert.Equal(t, "7", v)
+
+	// Test: Override replaces in place, Del removes
+	headers.Override("set-cookie", "c=3")
+	assert.Equal(t, []string{"c=3"}, headers.Values("Set-Cookie"))
+	headers.Del("X-CUSTOM-ID")
+	_, ok = headers.Get("X-Custom-ID")
+	assert.False(t, ok)
+	assert.Equal(t, 1, headers.Len())
+
+	// Test: Missing field
+	assert.Nil(t, headers.Values("Accept"))
+}
+
+func TestCanonicalName(t *testing.T) {
+	assert.Equal(t, "Content-Type", CanonicalName("content-type"))
+	assert.Equal(t, "X-Request-Id", CanonicalName("X-REQUEST-ID"))
+	assert.Equal(t, "Www-Authenticate", CanonicalName("www-authenticate"))
+	assert.Equal(t, "H$st", CanonicalName("H$st"))
+}
+
+func TestHeaderValueValidation(t *testing.T) {
+	// Test: Missing colon
+	headers := NewHeaders()
+	_, _, err := headers.Parse([]byte("Host localhost\r\n\r\n"))
+	require.Error(t, err)
+
+	// Test: Empty name
+	headers = NewHeaders()
+	_, _, err = headers.Parse([]byte(": localhost\r\n\r\n"))
+	require.Error(t, err)
+
+	// Test: Control characters in value
+	for _, value := range []string{"a\rb", "a\x00b", "a\nb", "a\x1bb", "a\x7fb"} {
+		headers = NewHeaders()
+		_, _, err = headers.Parse([]byte("X-Test: " + value + "\r\n\r\n"))
+		require.Error(t, err, "value %q", value)
+	}
+
+	// Test: Tabs, inner spaces and obs-text are allowed
+	headers = NewHeaders()
+	_, _, err = headers.Parse([]byte("X-Test:\ta \tb\xe9\t\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a \tb\xe9"}, headers.Values("x-test"))
+}
+
+func TestHeaderObsFold(t *testing.T) {
+	data := []byte("X-Folded: first\r\n  second\r\n\tthird\r\n\r\n")
+
+	// Test: Rejected by default
+	headers := NewHeaders()
+	n, _, err := headers.Parse(data)
+	require.NoError(t, err)
+	_, _, err = headers.Parse(data[n:])
+	require.Error(t, err)
+
+	// Test: Unfolded into the previous value
+	headers = NewHeaders()
+	total := 0
+	for {
+		n, done, err := headers.ParseWithPolicy(data[total:], ObsFoldUnfold)
+		require.NoError(t, err)
+		total += n
+		if done {
+			break
+		}
+	}
+	assert.Equal(t, len(data), total)
+	assert.Equal(t, []string{"first second third"}, headers.Values("x-folded"))
+
+	// Test: A continuation joins the value the previous line wrote, even
+	// when that line repeated an earlier field
+	data = []byte("A: 1\r\nB: 2\r\nA: 3\r\n  cont\r\n\r\n")
+	headers = NewHeaders()
+	total = 0
+	for {
+		n, done, err := headers.ParseWithPolicy(data[total:], ObsFoldUnfold)
+		require.NoError(t, err)
+		total += n
+		if done {
+			break
+		}
+	}
+	assert.Equal(t, []string{"1", "3 cont"}, headers.Values("A"))
+	assert.Equal(t, []string{"2"}, headers.Values("B"))
+
+	// Test: A first line starting with whitespace has nothing to fold into
+	for _, policy := range []ObsFoldPolicy{ObsFoldReject, ObsFoldUnfold} {
+		for _, line := range []string{" Host: evil\r\n\r\n", "\tHost: evil\r\n\r\n"} {
+			headers = NewHeaders()
+			_, _, err = headers.ParseWithPolicy([]byte(line), policy)
+			require.Error(t, err, "%q", line)
+			assert.Equal(t, 0, headers.Len())
+		}
+	}
 }
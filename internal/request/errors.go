@@ -0,0 +1,46 @@
+package request
+
+import "errors"
+
+// Error is a request parsing failure. Every error the parser returns wraps
+// one of the Err values below, which can be matched with errors.Is, and
+// errors.As to an *Error gives the status code to answer with.
+type Error struct {
+	StatusCode int
+	msg        string
+}
+
+func (e *Error) Error() string {
+	return e.msg
+}
+
+var (
+	ErrMalformedRequestLine = &Error{StatusCode: 400, msg: "malformed request line"}
+	ErrInvalidMethod        = &Error{StatusCode: 400, msg: "invalid method"}
+	ErrInvalidTarget        = &Error{StatusCode: 400, msg: "invalid request target"}
+	ErrInvalidHost          = &Error{StatusCode: 400, msg: "missing or invalid host"}
+	ErrUnsupportedVersion   = &Error{StatusCode: 505, msg: "unsupported HTTP version"}
+	ErrRequestLineTooLong   = &Error{StatusCode: 414, msg: "request line too long"}
+	ErrInvalidHeader        = &Error{StatusCode: 400, msg: "invalid header field"}
+	ErrHeadersTooLarge      = &Error{StatusCode: 431, msg: "request header fields too large"}
+	ErrBodyTooLarge         = &Error{StatusCode: 413, msg: "request body too large"}
+	ErrInvalidChunk         = &Error{StatusCode: 400, msg: "invalid chunked encoding"}
+	ErrIncomplete           = &Error{StatusCode: 400, msg: "incomplete request"}
+	ErrExpectationFailed    = &Error{StatusCode: 417, msg: "unsupported expectation"}
+
+	ErrInvalidContentLength              = &Error{StatusCode: 400, msg: "invalid Content-Length"}
+	ErrConflictingContentLength          = &Error{StatusCode: 400, msg: "conflicting Content-Length values"}
+	ErrContentLengthWithTransferEncoding = &Error{StatusCode: 400, msg: "both Content-Length and Transfer-Encoding present"}
+	ErrUnsupportedTransferEncoding       = &Error{StatusCode: 501, msg: "unsupported Transfer-Encoding"}
+	ErrTransferEncodingHTTP10            = &Error{StatusCode: 400, msg: "Transfer-Encoding in an HTTP/1.0 request"}
+)
+
+// StatusCode returns the status code suggested by the *Error err wraps, or
+// 400 if it wraps none.
+func StatusCode(err error) int {
+	var perr *Error
+	if errors.As(err, &perr) {
+		return perr.StatusCode
+	}
+	return 400
+}
@@ -0,0 +1,40 @@
+package request
+
+// Limits bounds how much a client can make the parser hold in memory. Zero
+// fields fall back to the matching DefaultLimits value.
+type Limits struct {
+	// MaxRequestLineLength is the longest request line accepted, CRLF
+	// excluded.
+	MaxRequestLineLength int
+	// MaxHeaderBytes caps the size of the header section, and separately of
+	// the trailer section, including line endings.
+	MaxHeaderBytes int
+	// MaxHeaderCount caps the number of header field lines, and separately
+	// of trailer field lines.
+	MaxHeaderCount int
+	// MaxBodySize caps the decoded body length.
+	MaxBodySize int
+}
+
+var DefaultLimits = Limits{
+	MaxRequestLineLength: 8 * 1024,
+	MaxHeaderBytes:       64 * 1024,
+	MaxHeaderCount:       100,
+	MaxBodySize:          10 * 1024 * 1024,
+}
+
+func (l Limits) withDefaults() Limits {
+	if l.MaxRequestLineLength == 0 {
+		l.MaxRequestLineLength = DefaultLimits.MaxRequestLineLength
+	}
+	if l.MaxHeaderBytes == 0 {
+		l.MaxHeaderBytes = DefaultLimits.MaxHeaderBytes
+	}
+	if l.MaxHeaderCount == 0 {
+		l.MaxHeaderCount = DefaultLimits.MaxHeaderCount
+	}
+	if l.MaxBodySize == 0 {
+		l.MaxBodySize = DefaultLimits.MaxBodySize
+	}
+	return l
+}
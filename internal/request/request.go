@@ -2,6 +2,7 @@ package request
 
 import (
 	"bytes"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -14,15 +15,32 @@ import (
 type Request struct {
 	RequestLine    RequestLine
 	parserState    requestState
-	Headers        headers.Headers
+	Headers        *headers.Headers
 	Body           []byte
+	Trailers       *headers.Headers
+	PathParams     map[string]string
 	bodyLengthRead int
+	contentLength  int
+	chunked        bool
+	chunkRemaining int
+	fieldBytes     int
+	fieldCount     int
+	limits         Limits
+	obsFold        headers.ObsFoldPolicy
+	body           *bodyReader
+	expectContinue bool
+	continueFunc   func() error
+
+	// TLS is the state negotiated on a TLS connection, including any client
+	// certificates, or nil for plain connections.
+	TLS *tls.ConnectionState
 }
 
 type RequestLine struct {
 	HttpVersion   string
 	RequestTarget string
 	Method        string
+	Target        Target
 }
 
 type requestState int
@@ -31,48 +49,240 @@ const (
 	requestStateInitialized requestState = iota
 	requestStateParsingHeaders
 	requestStateParsingBody
+	requestStateParsingChunkSize
+	requestStateParsingChunkData
+	requestStateParsingChunkEnd
+	requestStateParsingTrailers
 	requestStateDone
 )
 
 const crlf = "\r\n"
 const bufferSize = 8
+const bodyBufferSize = 32 * 1024
+const maxChunkLineLength = 4096
+
+// Reader parses consecutive requests from a single connection. Bytes read
+// past the end of one request are kept for the next, so pipelined requests
+// on a persistent connection are not lost.
+type Reader struct {
+	reader      io.Reader
+	buf         []byte
+	readToIndex int
+	limits      Limits
+	obsFold     headers.ObsFoldPolicy
+}
+
+func NewReader(reader io.Reader) *Reader {
+	return NewReaderWithLimits(reader, DefaultLimits)
+}
+
+func NewReaderWithLimits(reader io.Reader, limits Limits) *Reader {
+	return &Reader{
+		reader: reader,
+		buf:    make([]byte, bufferSize, bufferSize),
+		limits: limits.withDefaults(),
+	}
+}
+
+// SetObsFoldPolicy chooses whether header and trailer lines using obsolete
+// line folding are rejected, the default, or unfolded.
+func (rr *Reader) SetObsFoldPolicy(policy headers.ObsFoldPolicy) {
+	rr.obsFold = policy
+}
 
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	b := make([]byte, bufferSize, bufferSize)
-	readToIndex := 0
-	r := &Request{
+	return NewReader(reader).ReadRequest()
+}
+
+// ReadRequest parses the next request on the connection, buffering the whole
+// body into Request.Body. It returns io.EOF if the connection was closed
+// before any byte of a new request arrived.
+func (rr *Reader) ReadRequest() (*Request, error) {
+	r, err := rr.ReadRequestHeaders()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.ReadBody(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// WaitForRequest blocks until the first byte of the next request is
+// available, so callers can tell an idle connection from a slow request. It
+// returns io.EOF if the connection was closed first.
+func (rr *Reader) WaitForRequest() error {
+	if rr.readToIndex > 0 {
+		return nil
+	}
+	for {
+		n, err := rr.reader.Read(rr.buf)
+		rr.readToIndex += n
+		if n > 0 {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ReadRequestHeaders parses the next request line and headers and leaves the
+// body on the connection, to be read through Request.BodyReader. The body
+// must be read or closed before the next request is read.
+func (rr *Reader) ReadRequestHeaders() (*Request, error) {
+	r := newRequest(rr.limits, rr.obsFold)
+	for r.parserState < requestStateParsingBody {
+		if err := rr.step(r); err != nil {
+			return nil, err
+		}
+	}
+	r.body = &bodyReader{reader: rr, request: r}
+	return r, nil
+}
+
+func newRequest(limits Limits, obsFold headers.ObsFoldPolicy) *Request {
+	return &Request{
+		limits:      limits,
+		obsFold:     obsFold,
 		parserState: requestStateInitialized,
 		Headers:     headers.NewHeaders(),
 		Body:        make([]byte, 0),
+		Trailers:    headers.NewHeaders(),
 	}
-	for r.parserState != requestStateDone {
-		if readToIndex >= len(b) {
-			buf := make([]byte, cap(b)*2, cap(b)*2)
-			copy(buf, b)
-			b = buf
+}
+
+// step parses whatever is buffered into r and, if that makes no progress,
+// reads more from the connection.
+func (rr *Reader) step(r *Request) error {
+	state := r.parserState
+	parsed, err := r.parse(rr.buf[:rr.readToIndex])
+	if err != nil {
+		return err
+	}
+	copy(rr.buf, rr.buf[parsed:rr.readToIndex])
+	rr.readToIndex -= parsed
+	if parsed > 0 || r.parserState != state || r.parserState == requestStateDone {
+		return nil
+	}
+
+	if rr.readToIndex >= len(rr.buf) {
+		rr.grow(cap(rr.buf) * 2)
+	}
+	if r.parserState >= requestStateParsingBody && len(rr.buf) < bodyBufferSize {
+		rr.grow(bodyBufferSize)
+	}
+	n, err := rr.reader.Read(rr.buf[rr.readToIndex:])
+	rr.readToIndex += n
+	if err != nil {
+		if n > 0 && errors.Is(err, io.EOF) {
+			return nil
 		}
-		n, err := reader.Read(b[readToIndex:])
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				if r.parserState != requestStateDone {
-					return nil, fmt.Errorf("incomplete request: %s", err)
-				}
-				break
+		if errors.Is(err, io.EOF) {
+			if r.parserState == requestStateInitialized && rr.readToIndex == 0 {
+				return io.EOF
 			}
-			return nil, err
+			return fmt.Errorf("%w: %v", ErrIncomplete, err)
 		}
+		return err
+	}
+	return nil
+}
 
-		readToIndex += n
+func (rr *Reader) grow(size int) {
+	buf := make([]byte, size, size)
+	copy(buf, rr.buf[:rr.readToIndex])
+	rr.buf = buf
+}
 
-		parsed, err := r.parse(b[:readToIndex])
-		if err != nil {
-			return nil, err
+// bodyReader streams a request body off the connection, decoding it with the
+// same state machine ReadRequest uses. Decoded bytes pass through
+// Request.Body, which never holds more than one read's worth at a time.
+type bodyReader struct {
+	reader  *Reader
+	request *Request
+	err     error
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	r := b.request
+	if b.err == nil {
+		b.err = r.sendContinue()
+	}
+	for len(r.Body) == 0 && r.parserState != requestStateDone && b.err == nil {
+		b.err = b.reader.step(r)
+	}
+	if len(r.Body) > 0 {
+		n := copy(p, r.Body)
+		r.Body = r.Body[n:]
+		return n, nil
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return 0, io.EOF
+}
+
+// Close discards the rest of the body so the connection can carry the next
+// request.
+func (b *bodyReader) Close() error {
+	_, err := io.Copy(io.Discard, b)
+	return err
+}
+
+// ReadBody reads the rest of a body left on the connection by
+// ReadRequestHeaders into Body. Bytes already taken through BodyReader are
+// not included.
+func (r *Request) ReadBody() error {
+	if r.body == nil {
+		return nil
+	}
+	if err := r.sendContinue(); err != nil {
+		return err
+	}
+	for r.parserState != requestStateDone {
+		if err := r.body.reader.step(r); err != nil {
+			return err
 		}
+	}
+	r.body = nil
+	return nil
+}
+
+// ExpectsContinue reports whether the client sent "Expect: 100-continue"
+// and is still waiting to be told to send the body. A server can answer with
+// a final status such as 417 or 413 instead, and must then not read the body
+// and close the connection, since the client may or may not send it.
+func (r *Request) ExpectsContinue() bool {
+	return r.expectContinue
+}
 
-		copy(b, b[parsed:])
-		readToIndex -= parsed
+// SetContinueFunc sets f to be called once, just before the body is first
+// read, if the client is waiting for a 100 Continue. f is expected to send
+// it; an error from f is returned by the read.
+func (r *Request) SetContinueFunc(f func() error) {
+	r.continueFunc = f
+}
+
+func (r *Request) sendContinue() error {
+	if !r.expectContinue {
+		return nil
 	}
-	return r, nil
+	r.expectContinue = false
+	if r.continueFunc == nil {
+		return nil
+	}
+	return r.continueFunc()
+}
+
+// BodyReader returns the request body as a stream. For requests read with
+// ReadRequestHeaders it reads lazily from the connection; otherwise it reads
+// the already buffered Body.
+func (r *Request) BodyReader() io.ReadCloser {
+	if r.body == nil {
+		return io.NopCloser(bytes.NewReader(r.Body))
+	}
+	return r.body
 }
 
 func parseRequestLine(request []byte) (*RequestLine, int, error) {
@@ -91,49 +301,61 @@ func parseRequestLine(request []byte) (*RequestLine, int, error) {
 func requestLineFromString(str string) (*RequestLine, error) {
 	parts := strings.Split(str, " ")
 	if len(parts) != 3 {
-		return nil, fmt.Errorf("poorly formattred request-line: %s", parts)
+		return nil, fmt.Errorf("%w: %q", ErrMalformedRequestLine, str)
 	}
 
 	method := parts[0]
+	if method == "" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidMethod, method)
+	}
 	for _, c := range method {
 		if c < 'A' || c > 'Z' {
-			return nil, fmt.Errorf("invalid method: %s", method)
+			return nil, fmt.Errorf("%w: %q", ErrInvalidMethod, method)
 		}
 	}
 
 	requestTarget := parts[1]
+	target, err := parseTarget(method, requestTarget)
+	if err != nil {
+		return nil, err
+	}
 
 	versionParts := strings.Split(parts[2], "/")
 	if len(versionParts) != 2 {
-		return nil, fmt.Errorf("malformed start-line: %s", str)
+		return nil, fmt.Errorf("%w: %q", ErrMalformedRequestLine, str)
 	}
 
 	httpPart := versionParts[0]
 	if httpPart != "HTTP" {
-		return nil, fmt.Errorf("unrecognized HTTP-version: %s", httpPart)
+		return nil, fmt.Errorf("%w: %q", ErrMalformedRequestLine, str)
 	}
 
 	version := versionParts[1]
-	if version != "1.1" {
-		return nil, fmt.Errorf("unrecognized HTTP-version: %s", version)
+	if len(version) != 3 || !isDigit(version[0]) || version[1] != '.' || !isDigit(version[2]) {
+		return nil, fmt.Errorf("%w: %q", ErrMalformedRequestLine, str)
+	}
+	if version[0] != '1' {
+		return nil, fmt.Errorf("%w: HTTP/%s", ErrUnsupportedVersion, version)
 	}
 
 	return &RequestLine{
 		Method:        method,
 		RequestTarget: requestTarget,
 		HttpVersion:   versionParts[1],
+		Target:        target,
 	}, nil
 }
 
 func (r *Request) parse(data []byte) (int, error) {
 	totalBytesParsed := 0
 	for r.parserState != requestStateDone {
+		state := r.parserState
 		n, err := r.parseSingle(data[totalBytesParsed:])
 		if err != nil {
 			return 0, err
 		}
 		totalBytesParsed += n
-		if n == 0 {
+		if n == 0 && r.parserState == state {
 			break
 		}
 	}
@@ -145,45 +367,311 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 	case requestStateInitialized:
 		reqLine, consumed, err := parseRequestLine(data)
 		if err != nil {
-			return 0, fmt.Errorf("error failed to parse request: %v", err)
+			return 0, err
 		}
 		if consumed == 0 {
+			if len(data) > r.limits.MaxRequestLineLength {
+				return 0, ErrRequestLineTooLong
+			}
 			return 0, nil
 		}
+		if consumed-len(crlf) > r.limits.MaxRequestLineLength {
+			return 0, ErrRequestLineTooLong
+		}
 		r.RequestLine = *reqLine
 		r.parserState = requestStateParsingHeaders
 		return consumed, nil
 	case requestStateParsingHeaders:
-		n, done, err := r.Headers.Parse(data)
+		n, done, err := r.parseFields(r.Headers, data)
 		if err != nil {
-			return 0, fmt.Errorf("error failed to parse header: %v", err)
+			return 0, err
 		}
 		if done {
+			if err := r.setBodyFraming(); err != nil {
+				return 0, err
+			}
+			if err := r.checkHost(); err != nil {
+				return 0, err
+			}
+			if err := r.checkExpect(); err != nil {
+				return 0, err
+			}
 			r.parserState = requestStateParsingBody
 		}
 		return n, nil
 	case requestStateParsingBody:
-		contentLength, ok := r.Headers.Get("Content-Length")
-		if !ok {
+		if r.chunked {
+			r.parserState = requestStateParsingChunkSize
+			return 0, nil
+		}
+		if r.contentLength < 0 {
+			r.parserState = requestStateDone
+			return 0, nil
+		}
+		remaining := r.contentLength - r.bodyLengthRead
+		if len(data) > remaining {
+			data = data[:remaining]
+		}
+		r.Body = append(r.Body, data...)
+		r.bodyLengthRead += len(data)
+		if r.bodyLengthRead == r.contentLength {
 			r.parserState = requestStateDone
-			return len(data), nil
 		}
-		contentLengthNum, err := strconv.Atoi(contentLength)
+		return len(data), nil
+	case requestStateParsingChunkSize:
+		size, consumed, err := parseChunkSize(data)
 		if err != nil {
-			return 0, fmt.Errorf("malformed Content-Length: %v", err)
+			return 0, err
+		}
+		if consumed == 0 {
+			return 0, nil
+		}
+		if size > r.limits.MaxBodySize-r.bodyLengthRead {
+			return 0, fmt.Errorf("%w: chunked body exceeds %d", ErrBodyTooLarge, r.limits.MaxBodySize)
+		}
+		if size == 0 {
+			r.fieldBytes = 0
+			r.fieldCount = 0
+			r.parserState = requestStateParsingTrailers
+		} else {
+			r.chunkRemaining = size
+			r.parserState = requestStateParsingChunkData
+		}
+		return consumed, nil
+	case requestStateParsingChunkData:
+		if len(data) > r.chunkRemaining {
+			data = data[:r.chunkRemaining]
 		}
 		r.Body = append(r.Body, data...)
 		r.bodyLengthRead += len(data)
-		if r.bodyLengthRead > contentLengthNum {
-			return 0, fmt.Errorf("error: body greater than content-length: body: %v content-length: %v", len(r.Body), contentLengthNum)
+		r.chunkRemaining -= len(data)
+		if r.chunkRemaining == 0 {
+			r.parserState = requestStateParsingChunkEnd
+		}
+		return len(data), nil
+	case requestStateParsingChunkEnd:
+		if len(data) < len(crlf) {
+			return 0, nil
+		}
+		if !bytes.HasPrefix(data, []byte(crlf)) {
+			return 0, fmt.Errorf("%w: chunk data not terminated by CRLF", ErrInvalidChunk)
 		}
-		if r.bodyLengthRead == contentLengthNum {
+		r.parserState = requestStateParsingChunkSize
+		return len(crlf), nil
+	case requestStateParsingTrailers:
+		n, done, err := r.parseFields(r.Trailers, data)
+		if err != nil {
+			return 0, err
+		}
+		if done {
 			r.parserState = requestStateDone
 		}
-		return len(data), nil
+		return n, nil
 	case requestStateDone:
 		return 0, fmt.Errorf("error: trying to read data in done state")
 	default:
 		return 0, fmt.Errorf("unknown state")
 	}
 }
+
+// checkHost enforces RFC 9112 section 3.2: an HTTP/1.1 request needs
+// exactly one Host header, and no request may carry more than one.
+func (r *Request) checkHost() error {
+	hosts := r.Headers.Values("Host")
+	switch {
+	case len(hosts) > 1:
+		return fmt.Errorf("%w: %d Host headers", ErrInvalidHost, len(hosts))
+	case len(hosts) == 0:
+		if r.RequestLine.HttpVersion == "1.0" {
+			return nil
+		}
+		return fmt.Errorf("%w: no Host header", ErrInvalidHost)
+	}
+	host := hosts[0]
+	for i := 0; i < len(host); i++ {
+		if !isTargetChar(host[i]) || strings.IndexByte("/?@", host[i]) != -1 {
+			return fmt.Errorf("%w: %q", ErrInvalidHost, host)
+		}
+	}
+	return nil
+}
+
+// checkExpect looks at the Expect header. The only expectation defined is
+// 100-continue, which HTTP/1.0 requests must have ignored and which means
+// nothing without a body.
+func (r *Request) checkExpect() error {
+	expect := r.Headers.Values("Expect")
+	if len(expect) == 0 || r.RequestLine.HttpVersion == "1.0" {
+		return nil
+	}
+	for _, v := range expect {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" && !strings.EqualFold(t, "100-continue") {
+				return fmt.Errorf("%w: %q", ErrExpectationFailed, t)
+			}
+		}
+	}
+	r.expectContinue = r.chunked || r.contentLength > 0
+	return nil
+}
+
+// setBodyFraming decides how the body is delimited once the headers are in,
+// following RFC 9112 section 6.3. Anything ambiguous is rejected rather than
+// guessed at, since a proxy in front of us may have guessed differently and
+// let a second request be smuggled inside the body.
+func (r *Request) setBodyFraming() error {
+	r.contentLength = -1
+	codings := r.Headers.Values("Transfer-Encoding")
+	lengths := r.Headers.Values("Content-Length")
+	if len(codings) > 0 {
+		// HTTP/1.0 has no chunked coding, so a recipient may have framed
+		// the body some other way (RFC 9112 section 6.1).
+		if r.RequestLine.HttpVersion == "1.0" {
+			return ErrTransferEncodingHTTP10
+		}
+		if len(lengths) > 0 {
+			return ErrContentLengthWithTransferEncoding
+		}
+		tokens := []string{}
+		for _, v := range codings {
+			for _, t := range strings.Split(v, ",") {
+				tokens = append(tokens, strings.ToLower(strings.TrimSpace(t)))
+			}
+		}
+		if len(tokens) != 1 || tokens[0] != "chunked" {
+			return fmt.Errorf("%w: %s", ErrUnsupportedTransferEncoding, strings.Join(codings, ", "))
+		}
+		r.chunked = true
+		return nil
+	}
+
+	for _, v := range lengths {
+		for _, part := range strings.Split(v, ",") {
+			n, err := parseContentLength(strings.TrimSpace(part))
+			if err != nil {
+				return err
+			}
+			if r.contentLength != -1 && n != r.contentLength {
+				return fmt.Errorf("%w: %s", ErrConflictingContentLength, strings.Join(lengths, ", "))
+			}
+			r.contentLength = n
+		}
+	}
+	if r.contentLength > r.limits.MaxBodySize {
+		return fmt.Errorf("%w: Content-Length %d exceeds %d", ErrBodyTooLarge, r.contentLength, r.limits.MaxBodySize)
+	}
+	return nil
+}
+
+// parseContentLength accepts only 1*DIGIT, so signs, spaces and hex that
+// strconv would let through are refused.
+func parseContentLength(s string) (int, error) {
+	if s == "" {
+		return 0, fmt.Errorf("%w: empty", ErrInvalidContentLength)
+	}
+	for _, c := range []byte(s) {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidContentLength, s)
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidContentLength, s)
+	}
+	return n, nil
+}
+
+// parseFields parses one header or trailer field line into h, enforcing the
+// section size and field count limits.
+func (r *Request) parseFields(h *headers.Headers, data []byte) (int, bool, error) {
+	n, done, err := h.ParseWithPolicy(data, r.obsFold)
+	if err != nil {
+		return 0, false, fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+	}
+	if n == 0 {
+		if r.fieldBytes+len(data) > r.limits.MaxHeaderBytes {
+			return 0, false, fmt.Errorf("%w: more than %d bytes", ErrHeadersTooLarge, r.limits.MaxHeaderBytes)
+		}
+		return 0, false, nil
+	}
+	r.fieldBytes += n
+	if r.fieldBytes > r.limits.MaxHeaderBytes {
+		return 0, false, fmt.Errorf("%w: more than %d bytes", ErrHeadersTooLarge, r.limits.MaxHeaderBytes)
+	}
+	if !done {
+		r.fieldCount++
+		if r.fieldCount > r.limits.MaxHeaderCount {
+			return 0, false, fmt.Errorf("%w: more than %d fields", ErrHeadersTooLarge, r.limits.MaxHeaderCount)
+		}
+	}
+	return n, done, nil
+}
+
+// parseChunkSize reads a chunk-size line, ignoring any chunk extensions, and
+// returns the size along with the number of bytes consumed.
+func parseChunkSize(data []byte) (int, int, error) {
+	idx := bytes.Index(data, []byte(crlf))
+	if idx == -1 {
+		if len(data) > maxChunkLineLength {
+			return 0, 0, fmt.Errorf("%w: chunk size line longer than %d bytes", ErrInvalidChunk, maxChunkLineLength)
+		}
+		return 0, 0, nil
+	}
+	line := data[:idx]
+	if ext := bytes.IndexByte(line, ';'); ext != -1 {
+		line = line[:ext]
+	}
+	line = bytes.TrimRight(line, " \t")
+	if len(line) == 0 {
+		return 0, 0, fmt.Errorf("%w: missing chunk size", ErrInvalidChunk)
+	}
+	for _, c := range line {
+		if !isHexDigit(c) {
+			return 0, 0, fmt.Errorf("%w: invalid chunk size %q", ErrInvalidChunk, line)
+		}
+	}
+	size, err := strconv.ParseInt(string(line), 16, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: invalid chunk size %q", ErrInvalidChunk, line)
+	}
+	return int(size), idx + 2, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' ||
+		c >= 'a' && c <= 'f' ||
+		c >= 'A' && c <= 'F'
+}
+
+// PathParam returns the path segment captured under name by the route that
+// matched the request, with "*" naming a trailing wildcard. It returns "" if
+// there is no such parameter.
+func (r *Request) PathParam(name string) string {
+	return r.PathParams[name]
+}
+
+// Host returns the host the request is for, with its port if one was given.
+// An absolute-form target's authority takes precedence over the Host header,
+// as RFC 9112 section 3.2.2 requires.
+func (r *Request) Host() string {
+	if r.RequestLine.Target.Form == TargetAbsolute {
+		return r.RequestLine.Target.Authority
+	}
+	host, _ := r.Headers.Get("Host")
+	return host
+}
+
+// KeepAlive reports whether the client is willing to send another request on
+// the same connection after this one. HTTP/1.1 connections persist unless
+// the client says "Connection: close"; HTTP/1.0 ones only if it asks for
+// "Connection: keep-alive".
+func (r *Request) KeepAlive() bool {
+	if r.RequestLine.HttpVersion == "1.0" {
+		return r.Headers.HasToken("Connection", "keep-alive")
+	}
+	return !r.Headers.HasToken("Connection", "close")
+}
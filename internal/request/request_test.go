@@ -73,9 +73,9 @@ func TestRequestParseWithHeaders(t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, []string{"localhost:42069"}, r.Headers.Values("host"))
+	assert.Equal(t, []string{"curl/7.81.0"}, r.Headers.Values("user-agent"))
+	assert.Equal(t, []string{"*/*"}, r.Headers.Values("accept"))
 
 	// Test: Malformed Header
 	reader = &chunkReader{
@@ -100,8 +100,8 @@ func TestRequestParseWithHeaders(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0, curl/6.1.0", r.Headers["user-agent"])
+	assert.Equal(t, []string{"localhost:42069"}, r.Headers.Values("host"))
+	assert.Equal(t, []string{"curl/7.81.0", "curl/6.1.0"}, r.Headers.Values("user-agent"))
 
 	// Test: Case Insensitive Headers
 	reader = &chunkReader{
@@ -111,9 +111,9 @@ func TestRequestParseWithHeaders(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, []string{"localhost:42069"}, r.Headers.Values("host"))
+	assert.Equal(t, []string{"curl/7.81.0"}, r.Headers.Values("user-agent"))
+	assert.Equal(t, []string{"*/*"}, r.Headers.Values("accept"))
 	// Test: Missing End of Headers
 	reader = &chunkReader{
 		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*",
@@ -184,3 +184,459 @@ func TestRequestWithBody(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 }
+
+func TestReaderPipelinedRequests(t *testing.T) {
+	// Test: Two requests on one connection
+	reader := NewReader(&chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello" +
+			"GET /coffee HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Connection: close\r\n" +
+			"\r\n",
+		numBytesPerRead: 7,
+	})
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/submit", r.RequestLine.RequestTarget)
+	assert.Equal(t, "hello", string(r.Body))
+	assert.True(t, r.KeepAlive())
+
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/coffee", r.RequestLine.RequestTarget)
+	assert.False(t, r.KeepAlive())
+
+	// Test: Clean EOF between requests
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestRequestWithChunkedBody(t *testing.T) {
+	// Test: Chunked body with extension and trailers
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Trailer: X-Checksum\r\n" +
+			"\r\n" +
+			"6;name=value\r\n" +
+			"hello \r\n" +
+			"7\r\n" +
+			"world!\n\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc123\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "hello world!\n", string(r.Body))
+	assert.Equal(t, []string{"abc123"}, r.Trailers.Values("x-checksum"))
+
+	// Test: Chunked body without trailers
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"A\r\n" +
+			"0123456789\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 5,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(r.Body))
+	assert.Equal(t, 0, r.Trailers.Len())
+
+	// Test: Invalid chunk size
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"zz\r\n" +
+			"hello\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Chunk data longer than chunk size
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"3\r\n" +
+			"hello\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Missing terminating chunk
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\n" +
+			"hello\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+}
+
+func TestReadRequestHeadersStreamsBody(t *testing.T) {
+	// Test: Content-Length body streamed, next request still readable
+	reader := NewReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n" +
+			"GET / HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"\r\n",
+		numBytesPerRead: 4,
+	})
+	r, err := reader.ReadRequestHeaders()
+	require.NoError(t, err)
+	assert.Equal(t, "/upload", r.RequestLine.RequestTarget)
+	body, err := io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(body))
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/", r.RequestLine.RequestTarget)
+
+	// Test: Chunked body streamed, Close discards the unread rest
+	reader = NewReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n" +
+			"6\r\n world\r\n" +
+			"0\r\nX-Sum: 1\r\n\r\n" +
+			"GET /next HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	})
+	r, err = reader.ReadRequestHeaders()
+	require.NoError(t, err)
+	buf := make([]byte, 2)
+	n, err := r.BodyReader().Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "he", string(buf[:n]))
+	require.NoError(t, r.BodyReader().Close())
+	assert.Equal(t, []string{"1"}, r.Trailers.Values("x-sum"))
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+
+	// Test: Truncated body reports an error
+	reader = NewReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 20\r\n" +
+			"\r\n" +
+			"partial",
+		numBytesPerRead: 3,
+	})
+	r, err = reader.ReadRequestHeaders()
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.BodyReader())
+	require.Error(t, err)
+}
+
+func TestRequestLimits(t *testing.T) {
+	limits := Limits{
+		MaxRequestLineLength: 32,
+		MaxHeaderBytes:       64,
+		MaxHeaderCount:       2,
+		MaxBodySize:          8,
+	}
+
+	// Test: Request line too long, even without a CRLF
+	reader := NewReaderWithLimits(&chunkReader{
+		data:            "GET /" + strings.Repeat("a", 64),
+		numBytesPerRead: 3,
+	}, limits)
+	_, err := reader.ReadRequest()
+	require.ErrorIs(t, err, ErrRequestLineTooLong)
+
+	// Test: Header section too large
+	reader = NewReaderWithLimits(&chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nX-Long: " + strings.Repeat("a", 64) + "\r\n\r\n",
+		numBytesPerRead: 3,
+	}, limits)
+	_, err = reader.ReadRequest()
+	require.ErrorIs(t, err, ErrHeadersTooLarge)
+
+	// Test: Too many headers
+	reader = NewReaderWithLimits(&chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: a\r\nA: 1\r\nB: 2\r\n\r\n",
+		numBytesPerRead: 3,
+	}, limits)
+	_, err = reader.ReadRequest()
+	require.ErrorIs(t, err, ErrHeadersTooLarge)
+
+	// Test: Content-Length over the body limit
+	reader = NewReaderWithLimits(&chunkReader{
+		data:            "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 9\r\n\r\n123456789",
+		numBytesPerRead: 3,
+	}, limits)
+	_, err = reader.ReadRequest()
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Chunked body over the body limit
+	reader = NewReaderWithLimits(&chunkReader{
+		data:            "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5\r\n12345\r\n5\r\n12345\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}, limits)
+	_, err = reader.ReadRequest()
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Within all limits
+	reader = NewReaderWithLimits(&chunkReader{
+		data:            "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 8\r\n\r\n12345678",
+		numBytesPerRead: 3,
+	}, limits)
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "12345678", string(r.Body))
+}
+
+func TestRequestBodyFraming(t *testing.T) {
+	head := "POST /submit HTTP/1.1\r\nHost: localhost:42069\r\n"
+
+	// Test: Identical duplicate Content-Length is accepted
+	r, err := RequestFromReader(strings.NewReader(head + "Content-Length: 5\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+
+	// Test: Identical comma-separated Content-Length is accepted
+	r, err = RequestFromReader(strings.NewReader(head + "Content-Length: 5, 5\r\n\r\nhello"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+
+	// Test: Differing duplicate Content-Length
+	_, err = RequestFromReader(strings.NewReader(head + "Content-Length: 5\r\nContent-Length: 10\r\n\r\nhello"))
+	require.ErrorIs(t, err, ErrConflictingContentLength)
+
+	// Test: Signed and non-numeric Content-Length
+	for _, cl := range []string{"-5", "+5", "0x5", "5 5", ""} {
+		_, err = RequestFromReader(strings.NewReader(head + "Content-Length: " + cl + "\r\n\r\nhello"))
+		require.ErrorIs(t, err, ErrInvalidContentLength, "Content-Length %q", cl)
+	}
+
+	// Test: Content-Length and Transfer-Encoding together
+	_, err = RequestFromReader(strings.NewReader(head + "Content-Length: 5\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n"))
+	require.ErrorIs(t, err, ErrContentLengthWithTransferEncoding)
+
+	// Test: Transfer-Encoding other than chunked alone
+	for _, te := range []string{"gzip, chunked", "chunked, chunked", "identity", "chunked\r\nTransfer-Encoding: gzip"} {
+		_, err = RequestFromReader(strings.NewReader(head + "Transfer-Encoding: " + te + "\r\n\r\n0\r\n\r\n"))
+		require.ErrorIs(t, err, ErrUnsupportedTransferEncoding, "Transfer-Encoding %q", te)
+	}
+
+	// Test: Transfer-Encoding is case-insensitive
+	r, err = RequestFromReader(strings.NewReader(head + "Transfer-Encoding: Chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+}
+
+func TestRequestParseErrors(t *testing.T) {
+	tests := []struct {
+		data   string
+		err    error
+		status int
+	}{
+		{"GET /\r\n\r\n", ErrMalformedRequestLine, 400},
+		{"get / HTTP/1.1\r\n\r\n", ErrInvalidMethod, 400},
+		{"GET / HTTP/2.0\r\n\r\n", ErrUnsupportedVersion, 505},
+		{"GET / HTTP/one\r\n\r\n", ErrMalformedRequestLine, 400},
+		{"GET / HTTP/1.1\r\nHost localhost\r\n\r\n", ErrInvalidHeader, 400},
+		{"GET / HTTP/1.1\r\n Host: evil\r\n\r\n", ErrInvalidHeader, 400},
+		{"POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n X-Trailer: evil\r\n\r\n", ErrInvalidHeader, 400},
+		{"GET / HTTP/1.1\r\nHost: localhost\r\n", ErrIncomplete, 400},
+		{"POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\nxyz\r\n", ErrInvalidChunk, 400},
+		{"POST / HTTP/1.1\r\nTransfer-Encoding: gzip\r\n\r\n", ErrUnsupportedTransferEncoding, 501},
+	}
+	for _, tt := range tests {
+		_, err := RequestFromReader(strings.NewReader(tt.data))
+		require.ErrorIs(t, err, tt.err, "request %q", tt.data)
+		assert.Equal(t, tt.status, StatusCode(err), "request %q", tt.data)
+	}
+	assert.Equal(t, 400, StatusCode(io.ErrUnexpectedEOF))
+}
+
+func TestRequestHTTP10(t *testing.T) {
+	// Test: HTTP/1.0 closes unless keep-alive is asked for
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.RequestLine.HttpVersion)
+	assert.False(t, r.KeepAlive())
+
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.0\r\nConnection: Keep-Alive\r\n\r\n"))
+	require.NoError(t, err)
+	assert.True(t, r.KeepAlive())
+
+	// Test: Transfer-Encoding is faulty framing on HTTP/1.0, with or
+	// without Content-Length
+	for _, data := range []string{
+		"POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+		"POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\nContent-Length: 5\r\n\r\nhello",
+	} {
+		_, err = RequestFromReader(strings.NewReader(data))
+		require.ErrorIs(t, err, ErrTransferEncodingHTTP10, "request %q", data)
+		assert.Equal(t, 400, StatusCode(err))
+	}
+
+	// Test: HTTP/1.1 keeps alive unless close is asked for
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+	require.NoError(t, err)
+	assert.True(t, r.KeepAlive())
+
+	// Test: Unknown major versions
+	for _, version := range []string{"0.9", "2.0", "3.0"} {
+		_, err = RequestFromReader(strings.NewReader("GET / HTTP/" + version + "\r\n\r\n"))
+		require.ErrorIs(t, err, ErrUnsupportedVersion, "version %s", version)
+	}
+}
+
+func TestRequestTarget(t *testing.T) {
+	// Test: Origin form with an encoded path and a query
+	r, err := RequestFromReader(strings.NewReader("GET /a%20b/c?x=1&y=two%21&x=3 HTTP/1.1\r\nHost: a\r\n\r\n"))
+	require.NoError(t, err)
+	target := r.RequestLine.Target
+	assert.Equal(t, TargetOrigin, target.Form)
+	assert.Equal(t, "/a%20b/c", target.RawPath)
+	assert.Equal(t, "/a b/c", target.Path)
+	assert.Equal(t, "x=1&y=two%21&x=3", target.RawQuery)
+	assert.Equal(t, []string{"1", "3"}, target.Query["x"])
+	assert.Equal(t, "two!", target.Query.Get("y"))
+
+	// Test: Absolute form
+	r, err = RequestFromReader(strings.NewReader("GET HTTP://example.com:8080?q=1 HTTP/1.1\r\nHost: example.com:8080\r\n\r\n"))
+	require.NoError(t, err)
+	target = r.RequestLine.Target
+	assert.Equal(t, TargetAbsolute, target.Form)
+	assert.Equal(t, "http", target.Scheme)
+	assert.Equal(t, "example.com:8080", target.Authority)
+	assert.Equal(t, "/", target.Path)
+	assert.Equal(t, "1", target.Query.Get("q"))
+
+	// Test: Authority form for CONNECT
+	r, err = RequestFromReader(strings.NewReader("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, TargetAuthority, r.RequestLine.Target.Form)
+	assert.Equal(t, "example.com:443", r.RequestLine.Target.Authority)
+
+	// Test: Asterisk form for OPTIONS
+	r, err = RequestFromReader(strings.NewReader("OPTIONS * HTTP/1.1\r\nHost: a\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, TargetAsterisk, r.RequestLine.Target.Form)
+
+	// Test: Invalid targets
+	for _, line := range []string{
+		"GET * HTTP/1.1",
+		"GET example.com HTTP/1.1",
+		"GET /a#frag HTTP/1.1",
+		"GET /a%zz HTTP/1.1",
+		"GET /a?b=%zz HTTP/1.1",
+		"GET /a\"b HTTP/1.1",
+		"GET http:///nohost HTTP/1.1",
+		"CONNECT /path HTTP/1.1",
+		"CONNECT example.com HTTP/1.1",
+		"CONNECT example.com:https HTTP/1.1",
+	} {
+		_, err = RequestFromReader(strings.NewReader(line + "\r\nHost: a\r\n\r\n"))
+		require.ErrorIs(t, err, ErrInvalidTarget, line)
+		assert.Equal(t, 400, StatusCode(err))
+	}
+}
+
+func TestRequestHost(t *testing.T) {
+	// Test: Host header
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: Example.com:8080\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "Example.com:8080", r.Host())
+
+	// Test: Absolute-form authority wins over Host
+	r, err = RequestFromReader(strings.NewReader("GET http://proxied.example/ HTTP/1.1\r\nHost: other\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "proxied.example", r.Host())
+
+	// Test: HTTP/1.0 may leave Host out
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "", r.Host())
+
+	// Test: Missing, repeated and malformed Host headers
+	for _, data := range []string{
+		"GET / HTTP/1.1\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: a\r\nHost: b\r\n\r\n",
+		"GET / HTTP/1.0\r\nHost: a\r\nhost: a\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: a/b\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: user@a\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: a b\r\n\r\n",
+	} {
+		_, err = RequestFromReader(strings.NewReader(data))
+		require.ErrorIs(t, err, ErrInvalidHost, data)
+		assert.Equal(t, 400, StatusCode(err))
+	}
+}
+
+func TestRequestExpectContinue(t *testing.T) {
+	// Test: The continue func runs once, before the body is read
+	reader := NewReader(strings.NewReader("POST / HTTP/1.1\r\nHost: a\r\nExpect: 100-Continue\r\nContent-Length: 5\r\n\r\nhello"))
+	r, err := reader.ReadRequestHeaders()
+	require.NoError(t, err)
+	assert.True(t, r.ExpectsContinue())
+	calls := 0
+	r.SetContinueFunc(func() error {
+		calls++
+		return nil
+	})
+	body, err := io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	assert.Equal(t, 1, calls)
+	assert.False(t, r.ExpectsContinue())
+
+	// Test: An error from the continue func fails the read
+	reader = NewReader(strings.NewReader("POST / HTTP/1.1\r\nHost: a\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\nhello"))
+	r, err = reader.ReadRequestHeaders()
+	require.NoError(t, err)
+	r.SetContinueFunc(func() error { return io.ErrClosedPipe })
+	require.ErrorIs(t, r.ReadBody(), io.ErrClosedPipe)
+
+	// Test: Nothing to continue without a body or on HTTP/1.0
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: a\r\nExpect: 100-continue\r\n\r\n"))
+	require.NoError(t, err)
+	assert.False(t, r.ExpectsContinue())
+	reader = NewReader(strings.NewReader("POST / HTTP/1.0\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\nhello"))
+	r, err = reader.ReadRequestHeaders()
+	require.NoError(t, err)
+	assert.False(t, r.ExpectsContinue())
+
+	// Test: Unknown expectations
+	_, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: a\r\nExpect: 200-ok\r\nContent-Length: 5\r\n\r\nhello"))
+	require.ErrorIs(t, err, ErrExpectationFailed)
+	assert.Equal(t, 417, StatusCode(err))
+}
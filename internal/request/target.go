@@ -0,0 +1,114 @@
+package request
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// TargetForm is one of the four request-target forms of RFC 9112 section 3.2.
+type TargetForm int
+
+const (
+	// TargetOrigin is an absolute path with an optional query, "/a/b?c=d".
+	TargetOrigin TargetForm = iota
+	// TargetAbsolute is a full URI, "http://example.com/a", as sent to
+	// proxies.
+	TargetAbsolute
+	// TargetAuthority is "host:port", used only by CONNECT.
+	TargetAuthority
+	// TargetAsterisk is "*", used only by a server-wide OPTIONS.
+	TargetAsterisk
+)
+
+// Target is the request-target taken apart. Path and Query are decoded;
+// RawPath and RawQuery are as the client sent them.
+type Target struct {
+	Form TargetForm
+	// Scheme is set for the absolute form only.
+	Scheme string
+	// Authority is the host and optional port of the absolute and authority
+	// forms.
+	Authority string
+	RawPath   string
+	Path      string
+	RawQuery  string
+	Query     url.Values
+}
+
+// parseTarget classifies and decodes target, which method determines the
+// allowed forms of.
+func parseTarget(method, target string) (Target, error) {
+	for i := 0; i < len(target); i++ {
+		if !isTargetChar(target[i]) {
+			return Target{}, fmt.Errorf("%w: %q", ErrInvalidTarget, target)
+		}
+	}
+
+	switch {
+	case method == "CONNECT":
+		return parseAuthorityTarget(target)
+	case target == "*":
+		if method != "OPTIONS" {
+			return Target{}, fmt.Errorf("%w: * is only allowed for OPTIONS", ErrInvalidTarget)
+		}
+		return Target{Form: TargetAsterisk, Query: url.Values{}}, nil
+	case strings.HasPrefix(target, "/"):
+		rawPath, rawQuery, _ := strings.Cut(target, "?")
+		return newTarget(TargetOrigin, "", "", rawPath, rawQuery, target)
+	case strings.Contains(target, "://"):
+		u, err := url.Parse(target)
+		if err != nil || u.Host == "" || u.Opaque != "" || u.User != nil {
+			return Target{}, fmt.Errorf("%w: %q", ErrInvalidTarget, target)
+		}
+		rawPath := u.EscapedPath()
+		if rawPath == "" {
+			rawPath = "/"
+		}
+		return newTarget(TargetAbsolute, strings.ToLower(u.Scheme), u.Host, rawPath, u.RawQuery, target)
+	}
+	return Target{}, fmt.Errorf("%w: %q", ErrInvalidTarget, target)
+}
+
+func newTarget(form TargetForm, scheme, authority, rawPath, rawQuery, target string) (Target, error) {
+	path, err := url.PathUnescape(rawPath)
+	if err != nil {
+		return Target{}, fmt.Errorf("%w: %q", ErrInvalidTarget, target)
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return Target{}, fmt.Errorf("%w: %q", ErrInvalidTarget, target)
+	}
+	return Target{
+		Form:      form,
+		Scheme:    scheme,
+		Authority: authority,
+		RawPath:   rawPath,
+		Path:      path,
+		RawQuery:  rawQuery,
+		Query:     query,
+	}, nil
+}
+
+func parseAuthorityTarget(target string) (Target, error) {
+	idx := strings.LastIndex(target, ":")
+	if idx <= 0 || idx == len(target)-1 || strings.ContainsAny(target, "/?#@") {
+		return Target{}, fmt.Errorf("%w: CONNECT needs host:port, got %q", ErrInvalidTarget, target)
+	}
+	for _, c := range []byte(target[idx+1:]) {
+		if !isDigit(c) {
+			return Target{}, fmt.Errorf("%w: invalid port in %q", ErrInvalidTarget, target)
+		}
+	}
+	return Target{Form: TargetAuthority, Authority: target, Query: url.Values{}}, nil
+}
+
+// isTargetChar reports whether c may appear in a request-target: the
+// unreserved, sub-delims and gen-delims characters of RFC 3986 other than
+// "#", plus "%" for percent-encoding.
+func isTargetChar(c byte) bool {
+	if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' {
+		return true
+	}
+	return strings.IndexByte("-._~!$&'()*+,;=:@/?%[]", c) != -1
+}
@@ -0,0 +1,78 @@
+package response
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusText(t *testing.T) {
+	assert.Equal(t, "OK", StatusText(StatusOK))
+	assert.Equal(t, "Content Too Large", StatusText(StatusContentTooLarge))
+	assert.Equal(t, "I'm a teapot", StatusText(StatusTeapot))
+	assert.Equal(t, "", StatusText(599))
+}
+
+func TestWriteStatusLine(t *testing.T) {
+	tests := []struct {
+		name   string
+		code   StatusCode
+		reason string
+		custom bool
+		want   string
+	}{
+		{"registered reason phrase", StatusNotFound, "", false, "HTTP/1.1 404 Not Found\r\n"},
+		{"unregistered code", 599, "", false, "HTTP/1.1 599 \r\n"},
+		{"custom reason", StatusOK, "Alright", true, "HTTP/1.1 200 Alright\r\n"},
+		{"empty custom reason", StatusOK, "", true, "HTTP/1.1 200 \r\n"},
+		{"tab in reason", StatusOK, "A\tB", true, "HTTP/1.1 200 A\tB\r\n"},
+		{"three digit upper bound", 999, "", false, "HTTP/1.1 999 \r\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		var err error
+		if tt.custom {
+			err = w.WriteStatusLineWithReason(tt.code, tt.reason)
+		} else {
+			err = w.WriteStatusLine(tt.code)
+		}
+		require.NoError(t, err, tt.name)
+		assert.Equal(t, tt.want, buf.String(), tt.name)
+		assert.Equal(t, WriterHeaders, w.State(), tt.name)
+		assert.Equal(t, tt.code, w.StatusCode(), tt.name)
+	}
+}
+
+func TestWriteStatusLineErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		code   StatusCode
+		reason string
+	}{
+		{"code below 100", 99, "Low"},
+		{"zero code", 0, ""},
+		{"code above 999", 1000, "High"},
+		{"CR in reason", StatusOK, "OK\r\nX-Injected: 1"},
+		{"LF in reason", StatusOK, "OK\nX"},
+		{"NUL in reason", StatusOK, "O\x00K"},
+		{"DEL in reason", StatusOK, "O\x7fK"},
+	}
+	for _, tt := range tests {
+		// Test: Nothing is written and the status line can still be sent
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		require.Error(t, w.WriteStatusLineWithReason(tt.code, tt.reason), tt.name)
+		assert.Empty(t, buf.String(), tt.name)
+		assert.Equal(t, WriterStatusCode, w.State(), tt.name)
+		require.NoError(t, w.WriteStatusLine(StatusOK), tt.name)
+		assert.Equal(t, "HTTP/1.1 200 OK\r\n", buf.String(), tt.name)
+	}
+
+	// Test: WriteStatusLine rejects codes out of range too
+	var buf bytes.Buffer
+	require.Error(t, NewWriter(&buf).WriteStatusLine(1000))
+	assert.Empty(t, buf.String())
+}
@@ -3,15 +3,43 @@ package response
 import (
 	"fmt"
 	"io"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/iahta/httpfromtcp/internal/headers"
 )
 
 type Writer struct {
-	writer      io.Writer
-	writerState WriterState
+	writer             io.Writer
+	writerState        WriterState
+	keepAlive          bool
+	extraHeaders       *headers.Headers
+	headerOrder        HeaderOrder
+	preserveHeaderCase bool
+	version            string
+	unchunked          bool
+	statusCode         StatusCode
+	bytesWritten       int64
+	contentLength      int64
+	chunked            bool
+	finished           bool
+	method             string
+	noBody             bool
 }
 
+// HeaderOrder selects the order WriteHeaders and WriteTrailers emit fields
+// in. Either way the output is the same from run to run.
+type HeaderOrder int
+
+const (
+	// HeaderOrderInsertion writes fields in the order they were added, so
+	// handlers control what comes first.
+	HeaderOrderInsertion HeaderOrder = iota
+	// HeaderOrderSorted writes fields sorted by canonical name.
+	HeaderOrderSorted
+)
+
 type WriterState int
 
 const (
@@ -23,32 +51,182 @@ const (
 
 func NewWriter(w io.Writer) *Writer {
 	return &Writer{
-		writerState: WriterStatusCode,
-		writer:      w,
+		writerState:   WriterStatusCode,
+		writer:        w,
+		version:       "1.1",
+		contentLength: -1,
+	}
+}
+
+// SetKeepAlive tells the writer whether the connection may be reused after
+// this response. Writers start out closing the connection; the server turns
+// keep-alive on when the client and its own limits allow it.
+func (w *Writer) SetKeepAlive(keepAlive bool) {
+	w.keepAlive = keepAlive
+}
+
+// KeepAlive reports whether the connection can carry another request once
+// this response is complete. It is false if the handler never wrote its
+// headers, asked for "Connection: close" or left the body unframed, and
+// also if the body does not match its framing: fewer or more bytes than the
+// Content-Length, or a chunked body whose trailers were never written.
+// Reusing the connection then would glue the next response onto this one.
+func (w *Writer) KeepAlive() bool {
+	if !w.keepAlive || w.writerState == WriterStatusCode || w.writerState == WriterHeaders {
+		return false
+	}
+	if w.chunked {
+		return w.finished
+	}
+	return w.contentLength < 0 || w.bytesWritten == w.contentLength
+}
+
+// State reports which part of the response the writer expects next. Nothing
+// has reached the connection while it is still WriterStatusCode.
+func (w *Writer) State() WriterState {
+	return w.writerState
+}
+
+// StatusCode returns the status code of the final response, or 0 if the
+// status line has not been written.
+func (w *Writer) StatusCode() StatusCode {
+	return w.statusCode
+}
+
+// BytesWritten returns how many bytes of body have been written, not
+// counting the status line, headers, chunk framing or trailers.
+func (w *Writer) BytesWritten() int64 {
+	return w.bytesWritten
+}
+
+// SetHTTPVersion sets the version of the request being answered, which the
+// status line echoes. HTTP/1.0 clients cannot decode chunked bodies, so for
+// them a chunked response is sent as plain bytes delimited by closing the
+// connection, and its trailers are dropped. Versions other than "1.0" are
+// answered as HTTP/1.1.
+func (w *Writer) SetHTTPVersion(version string) {
+	if version != "1.0" {
+		version = "1.1"
 	}
+	w.version = version
 }
 
+// SetRequestMethod sets the method of the request being answered. A
+// response to HEAD has no body however its headers frame it, so body writes
+// are discarded.
+func (w *Writer) SetRequestMethod(method string) {
+	w.method = method
+}
+
+func (w *Writer) SetHeaderOrder(order HeaderOrder) {
+	w.headerOrder = order
+}
+
+// SetPreserveHeaderCase turns off canonicalizing field names on output, so a
+// proxy can pass names on with the casing it received them in.
+func (w *Writer) SetPreserveHeaderCase(preserve bool) {
+	w.preserveHeaderCase = preserve
+}
+
+// AddHeader queues a header to be sent along with the ones the handler passes
+// to WriteHeaders, which take precedence on conflict. It lets code wrapping a
+// handler contribute headers without building the response itself.
+func (w *Writer) AddHeader(key, value string) {
+	if w.extraHeaders == nil {
+		w.extraHeaders = headers.NewHeaders()
+	}
+	w.extraHeaders.Add(key, value)
+}
+
+// WriteStatusLine writes the status line with the registered reason phrase
+// for statusCode, left empty if there is none. Codes outside 100-999 are
+// rejected without writing anything.
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	return w.WriteStatusLineWithReason(statusCode, StatusText(statusCode))
+}
+
+// WriteStatusLineWithReason writes the status line with a custom reason
+// phrase, which must not contain control characters other than tab.
+func (w *Writer) WriteStatusLineWithReason(statusCode StatusCode, reason string) error {
 	if w.writerState != WriterStatusCode {
 		return fmt.Errorf("cannont write status line in state %d", w.writerState)
 	}
+	statusLine, err := getStatusLine(w.version, statusCode, reason)
+	if err != nil {
+		return err
+	}
 	defer func() { w.writerState = WriterHeaders }()
-	_, err := w.writer.Write(getStatusLine(statusCode))
+	w.statusCode = statusCode
+	_, err = w.write(statusLine)
+	return err
+}
+
+// WriteContinue sends the interim "100 Continue" response a client that sent
+// "Expect: 100-continue" waits for before sending the body. Once the final
+// response has started it writes nothing, since the client takes any final
+// status as its cue to go ahead.
+func (w *Writer) WriteContinue() error {
+	if w.writerState != WriterStatusCode {
+		return nil
+	}
+	statusLine, err := getStatusLine(w.version, StatusContinue, StatusText(StatusContinue))
+	if err != nil {
+		return err
+	}
+	_, err = w.write(append(statusLine, "\r\n"...))
 	return err
 }
 
-func (w *Writer) WriteHeaders(h headers.Headers) error {
+func (w *Writer) WriteHeaders(h *headers.Headers) error {
 	if w.writerState != WriterHeaders {
 		return fmt.Errorf("cannont write status line in state %d", w.writerState)
 	}
 	defer func() { w.writerState = WriterBody }()
-	for key, value := range h {
-		_, err := w.writer.Write([]byte(fmt.Sprintf("%s: %s\r\n", key, value)))
-		if err != nil {
-			return err
+	w.noBody = w.bodyless()
+	if h.HasToken("Connection", "close") || !w.noBody && !framed(h) {
+		w.keepAlive = false
+	}
+	if w.version == "1.0" && h.HasToken("Transfer-Encoding", "chunked") && !w.noBody {
+		w.unchunked = true
+		w.keepAlive = false
+	}
+	w.chunked = h.HasToken("Transfer-Encoding", "chunked") && !w.noBody
+	if cl, ok := h.Get("Content-Length"); ok && !w.chunked && !w.noBody {
+		n, err := strconv.ParseInt(cl, 10, 64)
+		if err != nil || n < 0 {
+			w.keepAlive = false
+		}
+		w.contentLength = n
+	}
+	out := headers.NewHeaders()
+	for name, values := range h.All() {
+		if strings.EqualFold(name, "Connection") {
+			continue
+		}
+		if w.unchunked && (strings.EqualFold(name, "Transfer-Encoding") || strings.EqualFold(name, "Trailer")) {
+			continue
+		}
+		for _, value := range values {
+			out.Add(name, value)
+		}
+	}
+	for name, values := range w.extraHeaders.All() {
+		if _, ok := h.Get(name); ok || strings.EqualFold(name, "Connection") {
+			continue
+		}
+		for _, value := range values {
+			out.Add(name, value)
 		}
 	}
-	_, err := w.writer.Write([]byte("\r\n"))
+	if !w.keepAlive {
+		out.Add("Connection", "close")
+	} else if w.version == "1.0" {
+		out.Add("Connection", "keep-alive")
+	}
+	if err := w.writeFields(out); err != nil {
+		return err
+	}
+	_, err := w.write([]byte("\r\n"))
 	return err
 }
 
@@ -56,23 +234,37 @@ func (w *Writer) WriteBody(p []byte) (int, error) {
 	if w.writerState != WriterBody {
 		return 0, fmt.Errorf("cannont write body in state: %d", w.writerState)
 	}
-	return w.writer.Write(p)
+	if w.noBody {
+		return w.discardBody(p)
+	}
+	n, err := w.write(p)
+	w.bytesWritten += int64(n)
+	return n, err
 }
 
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 	if w.writerState != WriterBody {
 		return 0, fmt.Errorf("cannont write body in state: %d", w.writerState)
 	}
+	if w.noBody {
+		return w.discardBody(p)
+	}
+	if w.unchunked {
+		n, err := w.write(p)
+		w.bytesWritten += int64(n)
+		return n, err
+	}
 	chunkLen := []byte(fmt.Sprintf("%x\r\n", len(p)))
-	_, err := w.writer.Write(chunkLen)
+	_, err := w.write(chunkLen)
 	if err != nil {
 		return 0, fmt.Errorf("cannont write body length: %v", err)
 	}
-	chunkWrit, err := w.writer.Write(p)
+	chunkWrit, err := w.write(p)
+	w.bytesWritten += int64(chunkWrit)
 	if err != nil {
 		return 0, fmt.Errorf("cannont write body: %v", err)
 	}
-	_, err = w.writer.Write([]byte("\r\n"))
+	_, err = w.write([]byte("\r\n"))
 	if err != nil {
 		return 0, fmt.Errorf("cannont write end body: %v", err)
 	}
@@ -83,8 +275,12 @@ func (w *Writer) WriteChunkedBodyDone() (int, error) {
 	if w.writerState != WriterBody {
 		return 0, fmt.Errorf("cannont write body done in state: %d", w.writerState)
 	}
+	if w.unchunked || w.noBody {
+		w.writerState = WriterTrailers
+		return 0, nil
+	}
 	done := []byte(fmt.Sprintf("0\r\n"))
-	doneLen, err := w.writer.Write(done)
+	doneLen, err := w.write(done)
 	if err != nil {
 		return 0, fmt.Errorf("cannont write length of end: %v", err)
 	}
@@ -92,16 +288,87 @@ func (w *Writer) WriteChunkedBodyDone() (int, error) {
 	return doneLen, nil
 }
 
-func (w *Writer) WriteTrailers(t headers.Headers) error {
+func (w *Writer) WriteTrailers(t *headers.Headers) error {
 	if w.writerState != WriterTrailers {
 		return fmt.Errorf("cannont write trailers done in state: %d", w.writerState)
 	}
-	for key, value := range t {
-		_, err := w.writer.Write([]byte(fmt.Sprintf("%s: %s\r\n", key, value)))
-		if err != nil {
-			return err
+	if w.unchunked || w.noBody {
+		return nil
+	}
+	if err := w.writeFields(t); err != nil {
+		return err
+	}
+	if _, err := w.write([]byte("\r\n")); err != nil {
+		return err
+	}
+	w.finished = true
+	return nil
+}
+
+// writeFields writes one line per value of every field in h, in the
+// writer's header order and with canonical names unless case is preserved.
+func (w *Writer) writeFields(h *headers.Headers) error {
+	type field struct {
+		name   string
+		values []string
+	}
+	fields := []field{}
+	for name, values := range h.All() {
+		if !w.preserveHeaderCase {
+			name = headers.CanonicalName(name)
 		}
+		fields = append(fields, field{name: name, values: values})
 	}
-	_, err := w.writer.Write([]byte("\r\n"))
-	return err
+	if w.headerOrder == HeaderOrderSorted {
+		slices.SortStableFunc(fields, func(a, b field) int {
+			return strings.Compare(headers.CanonicalName(a.name), headers.CanonicalName(b.name))
+		})
+	}
+	for _, f := range fields {
+		for _, value := range f.values {
+			_, err := w.write([]byte(fmt.Sprintf("%s: %s\r\n", f.name, value)))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// bodyless reports whether the response has no body by definition: it
+// answers HEAD, or its status is 1xx, 204 or 304 (RFC 9112 section 6.3).
+func (w *Writer) bodyless() bool {
+	return w.method == "HEAD" ||
+		w.statusCode < 200 ||
+		w.statusCode == StatusNoContent ||
+		w.statusCode == StatusNotModified
+}
+
+// discardBody drops body bytes for a response that cannot have a body.
+// Handlers shared between GET and HEAD may write them anyway, which is fine;
+// for a 1xx, 204 or 304 status it is a bug reported as an error.
+func (w *Writer) discardBody(p []byte) (int, error) {
+	if w.method == "HEAD" {
+		return len(p), nil
+	}
+	return 0, fmt.Errorf("status %d does not allow a body", w.statusCode)
+}
+
+// framed reports whether the headers tell the client where the body ends
+// without relying on the connection being closed.
+func framed(h *headers.Headers) bool {
+	if _, ok := h.Get("Content-Length"); ok {
+		return true
+	}
+	return h.HasToken("Transfer-Encoding", "chunked")
+}
+
+// write sends p to the connection. A failed write leaves the response
+// truncated, so the connection must not be reused afterwards.
+func (w *Writer) write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	if err != nil {
+		w.keepAlive = false
+	}
+	return n, err
 }
@@ -0,0 +1,232 @@
+package response
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+
+	"github.com/iahta/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterKeepAlive(t *testing.T) {
+	// Test: A short Content-Length body must not be reused
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetKeepAlive(true)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(10)))
+	_, err := w.WriteBody([]byte("abc"))
+	require.NoError(t, err)
+	assert.False(t, w.KeepAlive())
+
+	// Test: The full Content-Length body
+	_, err = w.WriteBody([]byte("defghij"))
+	require.NoError(t, err)
+	assert.True(t, w.KeepAlive())
+
+	// Test: Too many bytes
+	_, err = w.WriteBody([]byte("k"))
+	require.NoError(t, err)
+	assert.False(t, w.KeepAlive())
+
+	// Test: A chunked body needs its terminator and trailers
+	w = NewWriter(&buf)
+	w.SetKeepAlive(true)
+	h := headers.NewHeaders()
+	h.Add("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteChunkedBody([]byte("abc"))
+	require.NoError(t, err)
+	assert.False(t, w.KeepAlive())
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	assert.False(t, w.KeepAlive())
+	require.NoError(t, w.WriteTrailers(nil))
+	assert.True(t, w.KeepAlive())
+
+	// Test: Nothing written
+	w = NewWriter(&buf)
+	w.SetKeepAlive(true)
+	assert.False(t, w.KeepAlive())
+}
+
+func TestWriterBodylessResponses(t *testing.T) {
+	// Test: 204 and 304 need no framing and cannot have a body
+	for _, code := range []StatusCode{StatusNoContent, StatusNotModified} {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		w.SetKeepAlive(true)
+		w.SetRequestMethod("GET")
+		require.NoError(t, w.WriteStatusLine(code))
+		require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+		_, err := w.WriteBody([]byte("x"))
+		require.Error(t, err)
+		assert.True(t, w.KeepAlive())
+		assert.Equal(t, "HTTP/1.1 "+strconv.Itoa(int(code))+" "+StatusText(code)+"\r\n\r\n", buf.String())
+	}
+
+	// Test: The body of a response to HEAD is dropped
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetKeepAlive(true)
+	w.SetRequestMethod("HEAD")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	n, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.True(t, w.KeepAlive())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nContent-Type: text/plain\r\n\r\n", buf.String())
+
+	// Test: Also when the GET version of the response is chunked
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetKeepAlive(true)
+	w.SetRequestMethod("HEAD")
+	h := headers.NewHeaders()
+	h.Add("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(nil))
+	assert.True(t, w.KeepAlive())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n", buf.String())
+}
+
+func TestWriterHeaderFormatting(t *testing.T) {
+	newHeaders := func() *headers.Headers {
+		h := headers.NewHeaders()
+		h.Add("x-request-id", "abc")
+		h.Add("content-type", "text/plain")
+		h.Add("Set-Cookie", "a=1")
+		h.Add("set-cookie", "b=2")
+		h.Add("WWW-authenticate", "Basic")
+		h.Add("x_custom", "kept")
+		h.Add("content-length", "0")
+		return h
+	}
+	tests := []struct {
+		order    HeaderOrder
+		preserve bool
+		want     string
+	}{
+		// Test: Insertion order with canonical names
+		{HeaderOrderInsertion, false, "HTTP/1.1 200 OK\r\n" +
+			"X-Request-Id: abc\r\n" +
+			"Content-Type: text/plain\r\n" +
+			"Set-Cookie: a=1\r\n" +
+			"Set-Cookie: b=2\r\n" +
+			"Www-Authenticate: Basic\r\n" +
+			"x_custom: kept\r\n" +
+			"Content-Length: 0\r\n" +
+			"Server: test\r\n" +
+			"\r\n"},
+		// Test: Sorted by canonical name, values of a field kept in order
+		{HeaderOrderSorted, false, "HTTP/1.1 200 OK\r\n" +
+			"Content-Length: 0\r\n" +
+			"Content-Type: text/plain\r\n" +
+			"Server: test\r\n" +
+			"Set-Cookie: a=1\r\n" +
+			"Set-Cookie: b=2\r\n" +
+			"Www-Authenticate: Basic\r\n" +
+			"X-Request-Id: abc\r\n" +
+			"x_custom: kept\r\n" +
+			"\r\n"},
+		// Test: Preserved case keeps the name each field was first added with
+		{HeaderOrderInsertion, true, "HTTP/1.1 200 OK\r\n" +
+			"x-request-id: abc\r\n" +
+			"content-type: text/plain\r\n" +
+			"Set-Cookie: a=1\r\n" +
+			"Set-Cookie: b=2\r\n" +
+			"WWW-authenticate: Basic\r\n" +
+			"x_custom: kept\r\n" +
+			"content-length: 0\r\n" +
+			"server: test\r\n" +
+			"\r\n"},
+		// Test: Preserved case still sorts by canonical name
+		{HeaderOrderSorted, true, "HTTP/1.1 200 OK\r\n" +
+			"content-length: 0\r\n" +
+			"content-type: text/plain\r\n" +
+			"server: test\r\n" +
+			"Set-Cookie: a=1\r\n" +
+			"Set-Cookie: b=2\r\n" +
+			"WWW-authenticate: Basic\r\n" +
+			"x-request-id: abc\r\n" +
+			"x_custom: kept\r\n" +
+			"\r\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		w.SetKeepAlive(true)
+		w.SetHeaderOrder(tt.order)
+		w.SetPreserveHeaderCase(tt.preserve)
+		w.AddHeader("server", "test")
+		// Test: Headers from the handler win over queued ones
+		w.AddHeader("Content-Type", "text/html")
+		require.NoError(t, w.WriteStatusLine(StatusOK))
+		require.NoError(t, w.WriteHeaders(newHeaders()))
+		assert.Equal(t, tt.want, buf.String(), "order %d, preserve %v", tt.order, tt.preserve)
+	}
+}
+
+func TestWriterHTTP10(t *testing.T) {
+	// Test: A chunked response is sent as close-delimited plain bytes,
+	// without Transfer-Encoding, Trailer or the trailers themselves
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetHTTPVersion("1.0")
+	w.SetKeepAlive(true)
+	h := headers.NewHeaders()
+	h.Add("Content-Type", "text/plain")
+	h.Add("Transfer-Encoding", "chunked")
+	h.Add("Trailer", "X-Checksum")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.WriteChunkedBody([]byte("hello "))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBody([]byte("world"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	trailers := headers.NewHeaders()
+	trailers.Add("X-Checksum", "abc")
+	require.NoError(t, w.WriteTrailers(trailers))
+	assert.Equal(t, "HTTP/1.0 200 OK\r\nContent-Type: text/plain\r\nConnection: close\r\n\r\nhello world", buf.String())
+	assert.False(t, w.KeepAlive())
+
+	// Test: A framed response on a reused connection says keep-alive
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetHTTPVersion("1.0")
+	w.SetKeepAlive(true)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(2)))
+	_, err = w.WriteBody([]byte("ok"))
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.0 200 OK\r\nContent-Length: 2\r\nContent-Type: text/plain\r\nConnection: keep-alive\r\n\r\nok", buf.String())
+	assert.True(t, w.KeepAlive())
+
+	// Test: And close when it is not reused
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetHTTPVersion("1.0")
+	require.NoError(t, w.WriteStatusLine(StatusNotFound))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	assert.Equal(t, "HTTP/1.0 404 Not Found\r\nContent-Length: 0\r\nContent-Type: text/plain\r\nConnection: close\r\n\r\n", buf.String())
+
+	// Test: Other versions are answered as HTTP/1.1
+	for _, version := range []string{"1.1", "", "1.2"} {
+		buf.Reset()
+		w = NewWriter(&buf)
+		w.SetHTTPVersion(version)
+		require.NoError(t, w.WriteStatusLine(StatusOK))
+		assert.Equal(t, "HTTP/1.1 200 OK\r\n", buf.String(), "version %q", version)
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AccessLogFormat selects how an AccessLog writes its entries.
+type AccessLogFormat int
+
+const (
+	// AccessLogCommon is the Common Log Format:
+	//   host - - [time] "request line" status bytes
+	AccessLogCommon AccessLogFormat = iota
+	// AccessLogCombined is the Common Log Format followed by the quoted
+	// Referer and User-Agent.
+	AccessLogCombined
+	// AccessLogJSON writes one JSON object per line through log/slog, with
+	// every field of AccessLogEntry. Time, when the request started, is the
+	// "start" attribute; the record's own time is when it was logged.
+	AccessLogJSON
+)
+
+// clfTimeFormat is the timestamp layout of the Common Log Format.
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// AccessLogEntry describes one request and the response it got. Method,
+// Target and Proto are empty for requests that could not be parsed.
+type AccessLogEntry struct {
+	Time       time.Time
+	RemoteAddr string
+	Method     string
+	Target     string
+	Proto      string
+	Status     int
+	Bytes      int64
+	Duration   time.Duration
+	Referer    string
+	UserAgent  string
+}
+
+// AccessLog records an entry per request served, set through
+// ServerConfig.AccessLog. It is safe for concurrent use.
+type AccessLog struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format AccessLogFormat
+	logger *slog.Logger
+}
+
+// NewAccessLog returns an AccessLog writing entries to w in format.
+func NewAccessLog(w io.Writer, format AccessLogFormat) *AccessLog {
+	l := &AccessLog{w: w, format: format}
+	if format == AccessLogJSON {
+		l.logger = slog.New(slog.NewJSONHandler(w, nil))
+	}
+	return l
+}
+
+// NewSlogAccessLog returns an AccessLog passing entries to logger as
+// attributes of an info record, for any slog.Handler.
+func NewSlogAccessLog(logger *slog.Logger) *AccessLog {
+	return &AccessLog{format: AccessLogJSON, logger: logger}
+}
+
+// Log records e.
+func (l *AccessLog) Log(e AccessLogEntry) {
+	if l.logger != nil {
+		l.logger.LogAttrs(context.Background(), slog.LevelInfo, "request",
+			slog.Time("start", e.Time),
+			slog.String("remote_addr", e.RemoteAddr),
+			slog.String("method", e.Method),
+			slog.String("target", e.Target),
+			slog.String("proto", e.Proto),
+			slog.Int("status", e.Status),
+			slog.Int64("bytes", e.Bytes),
+			slog.Duration("duration", e.Duration),
+			slog.String("referer", e.Referer),
+			slog.String("user_agent", e.UserAgent),
+		)
+		return
+	}
+
+	requestLine := "-"
+	if e.Method != "" {
+		requestLine = e.Method + " " + e.Target + " " + e.Proto
+	}
+	bytes := "-"
+	if e.Bytes > 0 {
+		bytes = strconv.FormatInt(e.Bytes, 10)
+	}
+	line := fmt.Sprintf("%s - - [%s] %s %d %s", clfHost(e.RemoteAddr), e.Time.Format(clfTimeFormat), strconv.QuoteToASCII(requestLine), e.Status, bytes)
+	if l.format == AccessLogCombined {
+		line += " " + clfQuote(e.Referer) + " " + clfQuote(e.UserAgent)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.w, line+"\n")
+}
+
+// clfHost returns the host part of a remote address, or "-" if there is
+// none, as for Unix sockets.
+func clfHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	if addr == "" || addr == "@" {
+		return "-"
+	}
+	return addr
+}
+
+func clfQuote(s string) string {
+	if s == "" {
+		s = "-"
+	}
+	return strconv.QuoteToASCII(s)
+}
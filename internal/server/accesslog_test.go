@@ -0,0 +1,64 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testEntry = AccessLogEntry{
+	Time:       time.Date(2000, 10, 10, 13, 55, 36, 0, time.FixedZone("", -7*60*60)),
+	RemoteAddr: "127.0.0.1:51234",
+	Method:     "GET",
+	Target:     "/apache_pb.gif",
+	Proto:      "HTTP/1.0",
+	Status:     200,
+	Bytes:      2326,
+	Duration:   1500 * time.Microsecond,
+	Referer:    "http://www.example.com/start.html",
+	UserAgent:  `Mozilla/4.08 "quoted"`,
+}
+
+func TestAccessLogFormats(t *testing.T) {
+	tests := []struct {
+		format AccessLogFormat
+		entry  AccessLogEntry
+		want   string
+	}{
+		{AccessLogCommon, testEntry,
+			`127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326` + "\n"},
+		{AccessLogCombined, testEntry,
+			`127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08 \"quoted\""` + "\n"},
+		// Test: Unparsed requests, empty bodies and Unix sockets
+		{AccessLogCombined, AccessLogEntry{Time: testEntry.Time, RemoteAddr: "@", Status: 400},
+			`- - - [10/Oct/2000:13:55:36 -0700] "-" 400 - "-" "-"` + "\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		NewAccessLog(&buf, tt.format).Log(tt.entry)
+		assert.Equal(t, tt.want, buf.String())
+	}
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	var buf bytes.Buffer
+	NewAccessLog(&buf, AccessLogJSON).Log(testEntry)
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "request", record["msg"])
+	assert.Equal(t, "2000-10-10T13:55:36-07:00", record["start"])
+	assert.Equal(t, "127.0.0.1:51234", record["remote_addr"])
+	assert.Equal(t, "GET", record["method"])
+	assert.Equal(t, "/apache_pb.gif", record["target"])
+	assert.Equal(t, "HTTP/1.0", record["proto"])
+	assert.Equal(t, float64(200), record["status"])
+	assert.Equal(t, float64(2326), record["bytes"])
+	assert.Equal(t, float64(1500*time.Microsecond), record["duration"])
+	assert.Equal(t, "http://www.example.com/start.html", record["referer"])
+	assert.Equal(t, `Mozilla/4.08 "quoted"`, record["user_agent"])
+}
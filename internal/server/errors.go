@@ -0,0 +1,200 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/iahta/httpfromtcp/internal/request"
+	"github.com/iahta/httpfromtcp/internal/response"
+)
+
+// ErrorPages renders HandlerErrors as complete responses. The body format is
+// picked from the request's Accept header among plain text, HTML and JSON.
+// Each template is executed with an ErrorPage.
+type ErrorPages struct {
+	Text *texttemplate.Template
+	HTML *htmltemplate.Template
+	JSON *texttemplate.Template
+}
+
+type ErrorPage struct {
+	StatusCode int
+	Reason     string
+	Message    string
+}
+
+// DefaultErrorPages renders error responses wherever no other ErrorPages is
+// set: by servers, routers, virtual hosts and HandleErrors. Replace its
+// templates at startup to restyle them everywhere, or set ErrorPages on each
+// for different pages per server.
+var DefaultErrorPages = &ErrorPages{
+	Text: texttemplate.Must(texttemplate.New("text").Parse(
+		"{{.StatusCode}} {{.Reason}}\n{{.Message}}\n")),
+	HTML: htmltemplate.Must(htmltemplate.New("html").Parse(`<html>
+<head>
+<title>{{.StatusCode}} {{.Reason}}</title>
+</head>
+<body>
+<h1>{{.Reason}}</h1>
+<p>{{.Message}}</p>
+</body>
+</html>
+`)),
+	JSON: texttemplate.Must(texttemplate.New("json").Funcs(texttemplate.FuncMap{"json": toJSON}).Parse(
+		`{"status":{{.StatusCode}},"error":{{json .Reason}},"message":{{json .Message}}}` + "\n")),
+}
+
+// orDefault returns p, or DefaultErrorPages if p is nil.
+func (p *ErrorPages) orDefault() *ErrorPages {
+	if p == nil {
+		return DefaultErrorPages
+	}
+	return p
+}
+
+func toJSON(v any) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// HandleErrors adapts h to a Handler that renders its errors with
+// DefaultErrorPages.
+func HandleErrors(h ErrorHandler) Handler {
+	return DefaultErrorPages.HandleErrors(h)
+}
+
+// HandleErrors adapts h to a Handler. A returned *HandlerError is rendered
+// with its status code; any other error is logged and answered with 500. If h
+// had already started its response, the connection is closed instead.
+func (p *ErrorPages) HandleErrors(h ErrorHandler) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		err := h(w, req)
+		if err == nil {
+			return
+		}
+		var herr *HandlerError
+		if !errors.As(err, &herr) {
+			log.Printf("error serving %s %s: %v", req.RequestLine.Method, req.RequestLine.RequestTarget, err)
+			herr = &HandlerError{StatusCode: response.StatusInternalServerError}
+		}
+		if w.State() != response.WriterStatusCode {
+			w.SetKeepAlive(false)
+			return
+		}
+		if err := p.Write(w, req, herr); err != nil {
+			log.Printf("error writing error page for %s %s: %v", req.RequestLine.Method, req.RequestLine.RequestTarget, err)
+		}
+	}
+}
+
+// Write sends herr as a complete response. req may be nil when the request
+// could not be parsed, in which case plain text is used. If the template
+// fails, a plain-text page with just the status is sent instead and the
+// template's error returned, so the client still gets an answer.
+func (p *ErrorPages) Write(w *response.Writer, req *request.Request, herr *HandlerError) error {
+	page := ErrorPage{
+		StatusCode: int(herr.StatusCode),
+		Reason:     response.StatusText(herr.StatusCode),
+		Message:    herr.Message,
+	}
+	if page.Message == "" {
+		page.Message = page.Reason
+	}
+
+	accept := ""
+	if req != nil {
+		accept, _ = req.Headers.Get("Accept")
+	}
+	var body bytes.Buffer
+	var renderErr error
+	contentType := negotiate(accept)
+	switch contentType {
+	case "text/html":
+		renderErr = p.HTML.Execute(&body, page)
+	case "application/json":
+		renderErr = p.JSON.Execute(&body, page)
+	default:
+		renderErr = p.Text.Execute(&body, page)
+	}
+	if renderErr != nil {
+		body.Reset()
+		fmt.Fprintf(&body, "%d %s\n", page.StatusCode, page.Reason)
+		contentType = "text/plain"
+	}
+
+	if err := w.WriteStatusLine(herr.StatusCode); err != nil {
+		return errors.Join(renderErr, err)
+	}
+	h := response.GetDefaultHeaders(body.Len())
+	h.Override("Content-Type", contentType)
+	for name, values := range herr.Headers.All() {
+		h.Del(name)
+		for _, value := range values {
+			h.Add(name, value)
+		}
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return errors.Join(renderErr, err)
+	}
+	_, err := w.WriteBody(body.Bytes())
+	return errors.Join(renderErr, err)
+}
+
+var errorContentTypes = []string{"text/plain", "text/html", "application/json"}
+
+// negotiate picks the error page content type the Accept header weighs
+// highest, preferring plain text on a tie or when there is no Accept header.
+func negotiate(accept string) string {
+	best, bestQ := errorContentTypes[0], 0.0
+	if strings.TrimSpace(accept) == "" {
+		return best
+	}
+	for _, contentType := range errorContentTypes {
+		q := acceptQuality(accept, contentType)
+		if q > bestQ {
+			best, bestQ = contentType, q
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the q-value the Accept header gives contentType,
+// using the most specific matching media range.
+func acceptQuality(accept, contentType string) float64 {
+	mainType, _, _ := strings.Cut(contentType, "/")
+	q, specificity := 0.0, -1
+	for _, mediaRange := range strings.Split(accept, ",") {
+		params := strings.Split(mediaRange, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		s := -1
+		switch mediaType {
+		case contentType:
+			s = 2
+		case mainType + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		}
+		if s <= specificity {
+			continue
+		}
+		specificity = s
+		q = 1
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.TrimSpace(name) == "q" {
+				if v, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					q = v
+				}
+			}
+		}
+	}
+	return q
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"testing"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/iahta/httpfromtcp/internal/request"
+	"github.com/iahta/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", "text/plain"},
+		{"*/*", "text/plain"},
+		{"text/html", "text/html"},
+		{"application/json", "application/json"},
+		{"text/html, application/json;q=0.9", "text/html"},
+		{"text/html;q=0.5, application/json", "application/json"},
+		{"text/*", "text/plain"},
+		{"text/*;q=0.5, text/html", "text/html"},
+		{"application/json;q=0.2, */*;q=0.1", "application/json"},
+		{"TEXT/HTML", "text/html"},
+		{"text/html;q=0", "text/plain"},
+		{"image/png", "text/plain"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, negotiate(tt.accept), "Accept: %s", tt.accept)
+	}
+}
+
+func TestErrorPagesWrite(t *testing.T) {
+	// Test: Each format carries the status, reason and message
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"text/plain", "Content-Type: text/plain\r\nConnection: close\r\n\r\n404 Not Found\ngone\n"},
+		{"application/json", "Content-Type: application/json\r\nConnection: close\r\n\r\n{\"status\":404,\"error\":\"Not Found\",\"message\":\"gone\"}\n"},
+		{"text/html", "<title>404 Not Found</title>"},
+	}
+	h := HandleErrors(func(w *response.Writer, req *request.Request) error {
+		return &HandlerError{StatusCode: response.StatusNotFound, Message: "gone"}
+	})
+	for _, tt := range tests {
+		out := dispatch(t, h, "GET / HTTP/1.1\r\nHost: a\r\nAccept: "+tt.accept+"\r\n\r\n")
+		assert.Contains(t, out, "HTTP/1.1 404 Not Found\r\n", tt.accept)
+		assert.Contains(t, out, tt.want, tt.accept)
+	}
+}
+
+func customPages(text string) *ErrorPages {
+	return &ErrorPages{
+		Text: texttemplate.Must(texttemplate.New("text").Parse(text)),
+		HTML: DefaultErrorPages.HTML,
+		JSON: DefaultErrorPages.JSON,
+	}
+}
+
+func TestErrorPagesPerServer(t *testing.T) {
+	pages := customPages("custom {{.StatusCode}}\n")
+	failing := func(w *response.Writer, req *request.Request) error {
+		return &HandlerError{StatusCode: response.StatusTeapot}
+	}
+
+	rt := NewRouter()
+	rt.ErrorPages = pages
+	rt.Get("/fail", HandleErrors(failing))
+	// Test: Router pages are used for 404 and 405
+	assert.Contains(t, dispatch(t, rt.Dispatch, "GET /nowhere HTTP/1.1\r\nHost: a\r\n\r\n"), "\r\n\r\ncustom 404\n")
+	assert.Contains(t, dispatch(t, rt.Dispatch, "POST /fail HTTP/1.1\r\nHost: a\r\n\r\n"), "\r\n\r\ncustom 405\n")
+	// Test: The package-level HandleErrors keeps the default pages
+	assert.Contains(t, dispatch(t, rt.Dispatch, "GET /fail HTTP/1.1\r\nHost: a\r\n\r\n"), "\r\n\r\n418 I'm a teapot\n")
+
+	v := NewVirtualHosts()
+	v.ErrorPages = pages
+	// Test: Virtual host pages are used for 421
+	assert.Contains(t, dispatch(t, v.Dispatch, "GET / HTTP/1.1\r\nHost: b\r\n\r\n"), "\r\n\r\ncustom 421\n")
+
+	var s *Server
+	s = newTestServer(t, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.Target.RawPath == "/panic" {
+			panic("boom")
+		}
+		s.HandleErrors(failing)(w, req)
+	}, ServerConfig{ErrorPages: pages})
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		// Test: Server.HandleErrors uses the server's pages
+		{"GET / HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\n", "\r\n\r\ncustom 418\n"},
+		// Test: And so do malformed requests and panics
+		{"GET / HTTP/1.1\r\n\r\n", "\r\n\r\ncustom 400\n"},
+		{"GET /panic HTTP/1.1\r\nHost: a\r\n\r\n", "\r\n\r\ncustom 500\n"},
+	}
+	for _, tt := range tests {
+		conn := dial(t, s)
+		_, err := conn.Write([]byte(tt.raw))
+		require.NoError(t, err)
+		out, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		assert.Contains(t, string(out), tt.want, tt.raw)
+	}
+}
+
+func TestErrorPagesRejectOverLimit(t *testing.T) {
+	// Test: Connections over MaxConns get the server's pages
+	s := newTestServer(t, okHandler, ServerConfig{
+		MaxConns:        1,
+		RejectOverLimit: true,
+		ErrorPages:      customPages("custom {{.StatusCode}}\n"),
+	})
+	dial(t, s)
+	time.Sleep(20 * time.Millisecond)
+	out, err := io.ReadAll(dial(t, s))
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "\r\n\r\ncustom 503\n")
+}
+
+func TestHandleErrorsLogsWriteError(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	// Test: A page that fails to render is logged, and replaced by a plain
+	// one so the client still gets a response
+	pages := customPages("{{.Missing}}")
+	h := pages.HandleErrors(func(w *response.Writer, req *request.Request) error {
+		return &HandlerError{StatusCode: response.StatusNotFound}
+	})
+	out := dispatch(t, h, "GET /x HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 404 Not Found\r\nContent-Length: 14\r\nContent-Type: text/plain\r\nConnection: close\r\n\r\n404 Not Found\n", out)
+	assert.Contains(t, logs.String(), "error writing error page for GET /x: ")
+	assert.Contains(t, logs.String(), "Missing")
+}
@@ -1,6 +1,9 @@
 package server
 
 import (
+	"fmt"
+
+	"github.com/iahta/httpfromtcp/internal/headers"
 	"github.com/iahta/httpfromtcp/internal/request"
 	"github.com/iahta/httpfromtcp/internal/response"
 )
@@ -8,6 +11,16 @@ import (
 type HandlerError struct {
 	StatusCode response.StatusCode
 	Message    string
+	// Headers are sent along with the error page, e.g. Allow on a 405.
+	Headers *headers.Headers
+}
+
+func (e *HandlerError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, response.StatusText(e.StatusCode), e.Message)
 }
 
 type Handler func(w *response.Writer, req *request.Request)
+
+// ErrorHandler is a Handler that can fail instead of writing its own error
+// page. Wrap it with HandleErrors to use it as a Handler.
+type ErrorHandler func(w *response.Writer, req *request.Request) error
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/iahta/httpfromtcp/internal/request"
+	"github.com/iahta/httpfromtcp/internal/response"
+)
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the request
+// duration histogram buckets.
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// knownMethods are reported by name in the requests counter. Anything else
+// a client sends is counted as OTHER, so clients cannot blow up the number
+// of series.
+var knownMethods = []string{"CONNECT", "DELETE", "GET", "HEAD", "OPTIONS", "PATCH", "POST", "PUT", "TRACE"}
+
+// parseErrorTypes names the parse failures counted by type. Timeouts are
+// counted as "timeout" and anything else as "other".
+var parseErrorTypes = []struct {
+	err  error
+	name string
+}{
+	{request.ErrMalformedRequestLine, "malformed_request_line"},
+	{request.ErrInvalidMethod, "invalid_method"},
+	{request.ErrInvalidTarget, "invalid_target"},
+	{request.ErrInvalidHost, "invalid_host"},
+	{request.ErrUnsupportedVersion, "unsupported_version"},
+	{request.ErrRequestLineTooLong, "request_line_too_long"},
+	{request.ErrInvalidHeader, "invalid_header"},
+	{request.ErrHeadersTooLarge, "headers_too_large"},
+	{request.ErrBodyTooLarge, "body_too_large"},
+	{request.ErrInvalidChunk, "invalid_chunk"},
+	{request.ErrIncomplete, "incomplete"},
+	{request.ErrExpectationFailed, "expectation_failed"},
+	{request.ErrInvalidContentLength, "invalid_content_length"},
+	{request.ErrConflictingContentLength, "conflicting_content_length"},
+	{request.ErrContentLengthWithTransferEncoding, "content_length_with_transfer_encoding"},
+	{request.ErrUnsupportedTransferEncoding, "unsupported_transfer_encoding"},
+	{request.ErrTransferEncodingHTTP10, "transfer_encoding_http10"},
+}
+
+// Metrics counts what a server does, set through ServerConfig.Metrics, and
+// exposes the counts in the Prometheus text format through Handler. It
+// tracks requests by method and status, request latency, bytes read and
+// written on connections, active connections and parse errors by type.
+// Requests rejected as malformed only count as parse errors.
+type Metrics struct {
+	mu          sync.Mutex
+	requests    map[requestKey]uint64
+	buckets     []float64
+	bucketCount []uint64
+	latencySum  float64
+	latencyN    uint64
+	parseErrors map[string]uint64
+
+	bytesIn     atomic.Uint64
+	bytesOut    atomic.Uint64
+	activeConns atomic.Int64
+}
+
+type requestKey struct {
+	method string
+	status int
+}
+
+// NewMetrics returns empty metrics with DefaultLatencyBuckets.
+func NewMetrics() *Metrics {
+	return NewMetricsWithBuckets(DefaultLatencyBuckets)
+}
+
+// NewMetricsWithBuckets returns empty metrics whose latency histogram uses
+// the given bucket upper bounds in seconds.
+func NewMetricsWithBuckets(buckets []float64) *Metrics {
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+	return &Metrics{
+		requests:    map[requestKey]uint64{},
+		buckets:     buckets,
+		bucketCount: make([]uint64, len(buckets)),
+		parseErrors: map[string]uint64{},
+	}
+}
+
+func (m *Metrics) observeRequest(method string, status int, elapsed time.Duration) {
+	if !slices.Contains(knownMethods, method) {
+		method = "OTHER"
+	}
+	seconds := elapsed.Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestKey{method, status}]++
+	for i, le := range m.buckets {
+		if seconds <= le {
+			m.bucketCount[i]++
+		}
+	}
+	m.latencySum += seconds
+	m.latencyN++
+}
+
+func (m *Metrics) observeParseError(err error) {
+	name := "other"
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		name = "timeout"
+	}
+	for _, t := range parseErrorTypes {
+		if errors.Is(err, t.err) {
+			name = t.name
+			break
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.parseErrors[name]++
+}
+
+// countBytes wraps a connection so the bytes read from and written to it
+// are counted.
+func (m *Metrics) countBytes(rw io.ReadWriter) io.ReadWriter {
+	return &countingReadWriter{rw: rw, metrics: m}
+}
+
+type countingReadWriter struct {
+	rw      io.ReadWriter
+	metrics *Metrics
+}
+
+func (c *countingReadWriter) Read(p []byte) (int, error) {
+	n, err := c.rw.Read(p)
+	c.metrics.bytesIn.Add(uint64(n))
+	return n, err
+}
+
+func (c *countingReadWriter) Write(p []byte) (int, error) {
+	n, err := c.rw.Write(p)
+	c.metrics.bytesOut.Add(uint64(n))
+	return n, err
+}
+
+// Handler returns a Handler serving the metrics in the Prometheus text
+// exposition format, version 0.0.4.
+func (m *Metrics) Handler() Handler {
+	return func(w *response.Writer, req *request.Request) {
+		var buf bytes.Buffer
+		m.WriteTo(&buf)
+		w.WriteStatusLine(response.StatusOK)
+		h := response.GetDefaultHeaders(buf.Len())
+		h.Override("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.WriteHeaders(h)
+		w.WriteBody(buf.Bytes())
+	}
+}
+
+// WriteTo writes the metrics to w in the Prometheus text exposition format.
+// Series are sorted, so the output only changes when the counts do.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	m.mu.Lock()
+	requests := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		requests = append(requests, k)
+	}
+	slices.SortFunc(requests, func(a, b requestKey) int {
+		return cmp.Or(strings.Compare(a.method, b.method), cmp.Compare(a.status, b.status))
+	})
+	writeHeader(&buf, "http_server_requests_total", "counter", "Requests served, by method and status code.")
+	for _, k := range requests {
+		fmt.Fprintf(&buf, "http_server_requests_total{method=%q,status=\"%d\"} %d\n", k.method, k.status, m.requests[k])
+	}
+
+	writeHeader(&buf, "http_server_request_duration_seconds", "histogram", "Time from the start of a request to the end of its response.")
+	for i, le := range m.buckets {
+		fmt.Fprintf(&buf, "http_server_request_duration_seconds_bucket{le=%q} %d\n", formatFloat(le), m.bucketCount[i])
+	}
+	fmt.Fprintf(&buf, "http_server_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.latencyN)
+	fmt.Fprintf(&buf, "http_server_request_duration_seconds_sum %s\n", formatFloat(m.latencySum))
+	fmt.Fprintf(&buf, "http_server_request_duration_seconds_count %d\n", m.latencyN)
+
+	names := make([]string, 0, len(m.parseErrors))
+	for name := range m.parseErrors {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	writeHeader(&buf, "http_server_parse_errors_total", "counter", "Requests rejected as malformed, by error type.")
+	for _, name := range names {
+		fmt.Fprintf(&buf, "http_server_parse_errors_total{type=%q} %d\n", name, m.parseErrors[name])
+	}
+	m.mu.Unlock()
+
+	writeHeader(&buf, "http_server_received_bytes_total", "counter", "Bytes read from client connections.")
+	fmt.Fprintf(&buf, "http_server_received_bytes_total %d\n", m.bytesIn.Load())
+	writeHeader(&buf, "http_server_sent_bytes_total", "counter", "Bytes written to client connections.")
+	fmt.Fprintf(&buf, "http_server_sent_bytes_total %d\n", m.bytesOut.Load())
+	writeHeader(&buf, "http_server_active_connections", "gauge", "Connections currently being served.")
+	fmt.Fprintf(&buf, "http_server_active_connections %d\n", m.activeConns.Load())
+
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+func writeHeader(buf *bytes.Buffer, name, kind, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/iahta/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsWriteTo(t *testing.T) {
+	m := NewMetricsWithBuckets([]float64{0.5, 0.1})
+	m.observeRequest("GET", 200, 62500*time.Microsecond)
+	m.observeRequest("GET", 200, 250*time.Millisecond)
+	m.observeRequest("POST", 201, time.Second)
+	m.observeRequest("BREW", 418, 31250*time.Microsecond)
+	m.observeRequest("GET", 404, 31250*time.Microsecond)
+	m.observeParseError(fmt.Errorf("%w: bad", request.ErrInvalidHost))
+	m.observeParseError(request.ErrInvalidHost)
+	m.observeParseError(fmt.Errorf("boom"))
+	m.bytesIn.Add(100)
+	m.bytesOut.Add(250)
+	m.activeConns.Add(2)
+
+	var buf bytes.Buffer
+	_, err := m.WriteTo(&buf)
+	require.NoError(t, err)
+	want := `# HELP http_server_requests_total Requests served, by method and status code.
+# TYPE http_server_requests_total counter
+http_server_requests_total{method="GET",status="200"} 2
+http_server_requests_total{method="GET",status="404"} 1
+http_server_requests_total{method="OTHER",status="418"} 1
+http_server_requests_total{method="POST",status="201"} 1
+# HELP http_server_request_duration_seconds Time from the start of a request to the end of its response.
+# TYPE http_server_request_duration_seconds histogram
+http_server_request_duration_seconds_bucket{le="0.1"} 3
+http_server_request_duration_seconds_bucket{le="0.5"} 4
+http_server_request_duration_seconds_bucket{le="+Inf"} 5
+http_server_request_duration_seconds_sum 1.375
+http_server_request_duration_seconds_count 5
+# HELP http_server_parse_errors_total Requests rejected as malformed, by error type.
+# TYPE http_server_parse_errors_total counter
+http_server_parse_errors_total{type="invalid_host"} 2
+http_server_parse_errors_total{type="other"} 1
+# HELP http_server_received_bytes_total Bytes read from client connections.
+# TYPE http_server_received_bytes_total counter
+http_server_received_bytes_total 100
+# HELP http_server_sent_bytes_total Bytes written to client connections.
+# TYPE http_server_sent_bytes_total counter
+http_server_sent_bytes_total 250
+# HELP http_server_active_connections Connections currently being served.
+# TYPE http_server_active_connections gauge
+http_server_active_connections 2
+`
+	assert.Equal(t, want, buf.String())
+}
+
+func TestMetricsHandler(t *testing.T) {
+	out := dispatch(t, NewMetrics().Handler(), "GET /metrics HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.Contains(t, out, "HTTP/1.1 200 OK\r\n")
+	assert.Contains(t, out, "Content-Type: text/plain; version=0.0.4; charset=utf-8\r\n")
+	assert.Contains(t, out, "http_server_request_duration_seconds_count 0\n")
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"runtime/debug"
+	"time"
+
+	"github.com/iahta/httpfromtcp/internal/request"
+	"github.com/iahta/httpfromtcp/internal/response"
+)
+
+// Middleware wraps a Handler with behaviour that runs around it.
+type Middleware func(Handler) Handler
+
+// Chain wraps h in the given middleware. The first middleware is the
+// outermost, so it sees the request first and the response last.
+func Chain(h Handler, middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// Logging logs the method, target and handling time of every request. A nil
+// logger means log.Default.
+func Logging(logger *log.Logger) Middleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			next(w, req)
+			logger.Printf("%s %s %v", req.RequestLine.Method, req.RequestLine.RequestTarget, time.Since(start))
+		}
+	}
+}
+
+// Recover turns a panicking handler into a 500 response rendered with pages.
+// If the handler had already started its response, the connection is closed
+// instead. A nil logger means log.Default and nil pages DefaultErrorPages;
+// pass the server's ErrorPages to match its other error responses.
+func Recover(logger *log.Logger, pages *ErrorPages) Middleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				logger.Printf("panic serving %s %s: %v\n%s", req.RequestLine.Method, req.RequestLine.RequestTarget, v, debug.Stack())
+				pages.orDefault().writePanicResponse(w, req)
+			}()
+			next(w, req)
+		}
+	}
+}
+
+const requestIDHeader = "X-Request-Id"
+
+// RequestID makes sure every request carries an X-Request-Id header, keeping
+// one sent by the client and generating one otherwise, and echoes it on the
+// response.
+func RequestID() Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			id, ok := req.Headers.Get(requestIDHeader)
+			if !ok || id == "" {
+				id = newRequestID()
+				req.Headers.Override(requestIDHeader, id)
+			}
+			w.AddHeader(requestIDHeader, id)
+			next(w, req)
+		}
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Timing measures how long the wrapped handler takes and passes the result
+// to report, e.g. to feed a histogram.
+func Timing(report func(req *request.Request, elapsed time.Duration)) Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			next(w, req)
+			report(req, time.Since(start))
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"bytes"
+	"log"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iahta/httpfromtcp/internal/request"
+	"github.com/iahta/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveRaw runs h on a request parsed from raw with a keep-alive writer and
+// returns the writer and what it wrote.
+func serveRaw(t *testing.T, h Handler, raw string) (*response.Writer, string) {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	w.SetKeepAlive(true)
+	h(w, req)
+	return w, buf.String()
+}
+
+func TestChainOrder(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(w *response.Writer, req *request.Request) {
+				calls = append(calls, name+">")
+				next(w, req)
+				calls = append(calls, "<"+name)
+			}
+		}
+	}
+	h := Chain(func(w *response.Writer, req *request.Request) {
+		calls = append(calls, "handler")
+	}, trace("a"), trace("b"), trace("c"))
+
+	// Test: The first middleware is the outermost
+	serveRaw(t, h, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.Equal(t, []string{"a>", "b>", "c>", "handler", "<c", "<b", "<a"}, calls)
+}
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	h := Chain(func(w *response.Writer, req *request.Request) {
+		seen, _ = req.Headers.Get("X-Request-Id")
+		okHandler(w, req)
+	}, RequestID())
+
+	// Test: A client-supplied id is kept and echoed
+	_, out := serveRaw(t, h, "GET / HTTP/1.1\r\nHost: a\r\nX-Request-Id: abc-123\r\n\r\n")
+	assert.Equal(t, "abc-123", seen)
+	assert.Contains(t, out, "X-Request-Id: abc-123\r\n")
+
+	// Test: Otherwise one is generated and echoed
+	_, out = serveRaw(t, h, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.Regexp(t, regexp.MustCompile(`^[0-9a-f]{32}$`), seen)
+	assert.Contains(t, out, "X-Request-Id: "+seen+"\r\n")
+}
+
+func TestRecover(t *testing.T) {
+	var logs bytes.Buffer
+	logger := log.New(&logs, "", 0)
+
+	// Test: A panic before the response started is answered 500
+	h := Chain(func(w *response.Writer, req *request.Request) {
+		panic("boom")
+	}, Recover(logger, nil))
+	w, out := serveRaw(t, h, "GET /x HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 Internal Server Error\r\n"), out)
+	assert.Contains(t, out, "Connection: close\r\n")
+	assert.False(t, w.KeepAlive())
+	assert.Contains(t, logs.String(), "panic serving GET /x: boom")
+
+	// Test: With the given error pages
+	h = Chain(func(w *response.Writer, req *request.Request) {
+		panic("boom")
+	}, Recover(logger, customPages("custom {{.StatusCode}}\n")))
+	_, out = serveRaw(t, h, "GET /x HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.Contains(t, out, "\r\n\r\ncustom 500\n")
+
+	// Test: A panic after the headers only closes the connection
+	h = Chain(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(2))
+		panic("boom")
+	}, Recover(logger, nil))
+	w, out = serveRaw(t, h, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nContent-Type: text/plain\r\n\r\n", out)
+	assert.False(t, w.KeepAlive())
+}
+
+func TestLoggingAndTiming(t *testing.T) {
+	var logs bytes.Buffer
+	var reported *request.Request
+	var elapsed time.Duration
+	h := Chain(func(w *response.Writer, req *request.Request) {
+		time.Sleep(time.Millisecond)
+		okHandler(w, req)
+	}, Logging(log.New(&logs, "", 0)), Timing(func(req *request.Request, d time.Duration) {
+		reported, elapsed = req, d
+	}))
+
+	_, out := serveRaw(t, h, "POST /items?x=1 HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.Contains(t, out, "\r\n\r\nok")
+	assert.True(t, strings.HasPrefix(logs.String(), "POST /items?x=1 "), logs.String())
+	require.NotNil(t, reported)
+	assert.Equal(t, "/items?x=1", reported.RequestLine.RequestTarget)
+	assert.GreaterOrEqual(t, elapsed, time.Millisecond)
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/iahta/httpfromtcp/internal/headers"
+	"github.com/iahta/httpfromtcp/internal/request"
+	"github.com/iahta/httpfromtcp/internal/response"
+)
+
+// Router dispatches requests to handlers registered by method and path
+// pattern. Patterns are made of "/"-separated segments, each either a
+// literal, a "{name}" parameter matching any single non-empty segment, or,
+// as the last segment only, a "*" wildcard matching the rest of the path.
+// When several routes match, literal segments win over parameters and
+// parameters over wildcards, from left to right.
+type Router struct {
+	routes []route
+	// NotFound handles requests no route matches. Nil means a 404 page.
+	NotFound Handler
+	// ErrorPages renders the 404 and 405 pages. Nil means DefaultErrorPages.
+	ErrorPages *ErrorPages
+}
+
+type route struct {
+	method   string
+	segments []segment
+	handler  Handler
+}
+
+type segmentKind int
+
+const (
+	segmentLiteral segmentKind = iota
+	segmentParam
+	segmentWildcard
+)
+
+type segment struct {
+	kind  segmentKind
+	value string
+}
+
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Handle registers h for requests with the given method whose path matches
+// pattern. It panics on a malformed pattern, as that is a programming error.
+func (rt *Router) Handle(method, pattern string, h Handler) {
+	segments, err := parsePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+	rt.routes = append(rt.routes, route{
+		method:   method,
+		segments: segments,
+		handler:  h,
+	})
+}
+
+func (rt *Router) Get(pattern string, h Handler) {
+	rt.Handle("GET", pattern, h)
+}
+
+func (rt *Router) Post(pattern string, h Handler) {
+	rt.Handle("POST", pattern, h)
+}
+
+// Dispatch is a Handler that runs the best matching route. HEAD requests
+// fall back to the GET route when no HEAD route matches, since the writer
+// discards the body. If the path matches only routes for other methods it
+// answers 405 with an Allow header.
+func (rt *Router) Dispatch(w *response.Writer, req *request.Request) {
+	path, ok := splitPath(req.RequestLine.Target.RawPath)
+	if !ok {
+		rt.ErrorPages.orDefault().Write(w, req, &HandlerError{StatusCode: response.StatusNotFound})
+		return
+	}
+	method := req.RequestLine.Method
+	var best, fallback *route
+	var bestParams, fallbackParams map[string]string
+	allowed := []string{}
+	for i := range rt.routes {
+		r := &rt.routes[i]
+		params, ok := r.match(path)
+		if !ok {
+			continue
+		}
+		if !slices.Contains(allowed, r.method) {
+			allowed = append(allowed, r.method)
+		}
+		switch {
+		case r.method == method:
+			if best == nil || r.moreSpecific(best) {
+				best = r
+				bestParams = params
+			}
+		case method == "HEAD" && r.method == "GET":
+			if fallback == nil || r.moreSpecific(fallback) {
+				fallback = r
+				fallbackParams = params
+			}
+		}
+	}
+	if best == nil {
+		best, bestParams = fallback, fallbackParams
+	}
+
+	if best != nil {
+		req.PathParams = bestParams
+		best.handler(w, req)
+		return
+	}
+	if len(allowed) > 0 {
+		if slices.Contains(allowed, "GET") && !slices.Contains(allowed, "HEAD") {
+			allowed = append(allowed, "HEAD")
+		}
+		slices.Sort(allowed)
+		allow := headers.NewHeaders()
+		allow.Add("Allow", strings.Join(allowed, ", "))
+		rt.ErrorPages.orDefault().Write(w, req, &HandlerError{
+			StatusCode: response.StatusMethodNotAllowed,
+			Headers:    allow,
+		})
+		return
+	}
+	if rt.NotFound != nil {
+		rt.NotFound(w, req)
+		return
+	}
+	rt.ErrorPages.orDefault().Write(w, req, &HandlerError{StatusCode: response.StatusNotFound})
+}
+
+func parsePattern(pattern string) ([]segment, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("route pattern must start with /: %q", pattern)
+	}
+	parts := strings.Split(pattern[1:], "/")
+	segments := make([]segment, 0, len(parts))
+	for i, part := range parts {
+		switch {
+		case part == "*":
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("wildcard must be the last segment: %q", pattern)
+			}
+			segments = append(segments, segment{kind: segmentWildcard, value: "*"})
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}"):
+			name := part[1 : len(part)-1]
+			if name == "" {
+				return nil, fmt.Errorf("empty parameter name: %q", pattern)
+			}
+			segments = append(segments, segment{kind: segmentParam, value: name})
+		default:
+			segments = append(segments, segment{kind: segmentLiteral, value: part})
+		}
+	}
+	return segments, nil
+}
+
+// splitPath returns the percent-decoded segments of a raw request path.
+// Splitting before decoding keeps an encoded "/" inside its segment. It
+// reports false for targets without a path, like "*" or "host:port".
+func splitPath(rawPath string) ([]string, bool) {
+	if !strings.HasPrefix(rawPath, "/") {
+		return nil, false
+	}
+	segments := strings.Split(rawPath[1:], "/")
+	for i, seg := range segments {
+		decoded, err := url.PathUnescape(seg)
+		if err != nil {
+			return nil, false
+		}
+		segments[i] = decoded
+	}
+	return segments, true
+}
+
+func (r *route) match(path []string) (map[string]string, bool) {
+	params := map[string]string{}
+	for i, seg := range r.segments {
+		if seg.kind == segmentWildcard {
+			params["*"] = strings.Join(path[i:], "/")
+			return params, true
+		}
+		if i >= len(path) {
+			return nil, false
+		}
+		switch seg.kind {
+		case segmentLiteral:
+			if path[i] != seg.value {
+				return nil, false
+			}
+		case segmentParam:
+			if path[i] == "" {
+				return nil, false
+			}
+			params[seg.value] = path[i]
+		}
+	}
+	if len(path) != len(r.segments) {
+		return nil, false
+	}
+	return params, true
+}
+
+func (r *route) moreSpecific(other *route) bool {
+	for i := 0; i < len(r.segments) && i < len(other.segments); i++ {
+		if r.segments[i].kind != other.segments[i].kind {
+			return r.segments[i].kind < other.segments[i].kind
+		}
+	}
+	return len(r.segments) > len(other.segments)
+}
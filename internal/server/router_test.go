@@ -0,0 +1,169 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/iahta/httpfromtcp/internal/request"
+	"github.com/iahta/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustRoute(t *testing.T, pattern string) *route {
+	t.Helper()
+	segments, err := parsePattern(pattern)
+	require.NoError(t, err)
+	return &route{segments: segments}
+}
+
+func TestRouteMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		params  map[string]string
+		ok      bool
+	}{
+		{"/", "/", map[string]string{}, true},
+		{"/", "/a", nil, false},
+		{"/users", "/users", map[string]string{}, true},
+		{"/users", "/users/", nil, false},
+		{"/users/{id}", "/users/42", map[string]string{"id": "42"}, true},
+		{"/users/{id}", "/users/", nil, false},
+		{"/users/{id}", "/users/42/posts", nil, false},
+		{"/users/{id}/posts/{post}", "/users/1/posts/2", map[string]string{"id": "1", "post": "2"}, true},
+		{"/files/*", "/files/a/b/c", map[string]string{"*": "a/b/c"}, true},
+		{"/files/*", "/files/", map[string]string{"*": ""}, true},
+		{"/files/*", "/other/a", nil, false},
+		{"/users/{id}", "/users/a%2Fb", map[string]string{"id": "a/b"}, true},
+		{"/a b", "/a%20b", map[string]string{}, true},
+	}
+	for _, tt := range tests {
+		path, ok := splitPath(strings.SplitN(tt.path, "?", 2)[0])
+		require.True(t, ok, tt.path)
+		params, ok := mustRoute(t, tt.pattern).match(path)
+		assert.Equal(t, tt.ok, ok, "%s against %s", tt.path, tt.pattern)
+		if tt.ok {
+			assert.Equal(t, tt.params, params, "%s against %s", tt.path, tt.pattern)
+		}
+	}
+
+	// Test: Targets without a path match nothing
+	for _, raw := range []string{"", "*", "example.com:443", "/a%zz"} {
+		_, ok := splitPath(raw)
+		assert.False(t, ok, raw)
+	}
+}
+
+func TestRouteMoreSpecific(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"/users/me", "/users/{id}", true},
+		{"/users/{id}", "/users/me", false},
+		{"/users/{id}", "/users/*", true},
+		{"/users/*", "/users/{id}", false},
+		{"/users/me", "/users/*", true},
+		{"/a/{x}/c", "/a/{x}/*", true},
+		{"/{x}/b", "/a/{y}", false},
+		{"/a/{y}", "/{x}/b", true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, mustRoute(t, tt.a).moreSpecific(mustRoute(t, tt.b)), "%s over %s", tt.a, tt.b)
+	}
+}
+
+func TestParsePatternErrors(t *testing.T) {
+	for _, pattern := range []string{"users", "/files/*/more", "/users/{}"} {
+		_, err := parsePattern(pattern)
+		assert.Error(t, err, pattern)
+	}
+}
+
+// dispatch runs h on a request parsed from raw and returns the response.
+func dispatch(t *testing.T, h Handler, raw string) string {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	h(response.NewWriter(&buf), req)
+	return buf.String()
+}
+
+func named(body string) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	}
+}
+
+func TestRouterDispatch(t *testing.T) {
+	rt := NewRouter()
+	rt.Get("/users/{id}", func(w *response.Writer, req *request.Request) {
+		named("user "+req.PathParam("id"))(w, req)
+	})
+	rt.Get("/users/me", named("me"))
+	rt.Post("/users/{id}", named("post"))
+	rt.Handle("DELETE", "/users/{id}", named("delete"))
+	rt.Get("/static/*", func(w *response.Writer, req *request.Request) {
+		named("static "+req.PathParam("*"))(w, req)
+	})
+
+	tests := []struct {
+		raw      string
+		contains []string
+	}{
+		// Test: Literals win over parameters
+		{"GET /users/me HTTP/1.1\r\nHost: a\r\n\r\n", []string{"200 OK", "\r\n\r\nme"}},
+		// Test: Parameters are decoded after splitting the path
+		{"GET /users/a%2Fb?x=1 HTTP/1.1\r\nHost: a\r\n\r\n", []string{"200 OK", "user a/b"}},
+		{"GET /static/css/site.css HTTP/1.1\r\nHost: a\r\n\r\n", []string{"static css/site.css"}},
+		// Test: Absolute-form targets route on their path
+		{"GET http://example.com/users/7 HTTP/1.1\r\nHost: example.com\r\n\r\n", []string{"user 7"}},
+		// Test: Other methods on a known path get 405 with Allow
+		{"PUT /users/7 HTTP/1.1\r\nHost: a\r\n\r\n", []string{"405 Method Not Allowed", "Allow: DELETE, GET, HEAD, POST\r\n"}},
+		// Test: HEAD falls back to the GET route
+		{"HEAD /users/me HTTP/1.1\r\nHost: a\r\n\r\n", []string{"200 OK", "Content-Length: 2\r\n"}},
+		{"HEAD /static/app.js HTTP/1.1\r\nHost: a\r\n\r\n", []string{"200 OK"}},
+		{"GET /nowhere HTTP/1.1\r\nHost: a\r\n\r\n", []string{"404 Not Found"}},
+		{"OPTIONS * HTTP/1.1\r\nHost: a\r\n\r\n", []string{"404 Not Found"}},
+	}
+	for _, tt := range tests {
+		out := dispatch(t, rt.Dispatch, tt.raw)
+		for _, want := range tt.contains {
+			assert.Contains(t, out, want, tt.raw)
+		}
+	}
+
+	// Test: A HEAD route wins over the GET fallback
+	rt.Handle("HEAD", "/users/{id}", named("head"))
+	assert.Contains(t, dispatch(t, rt.Dispatch, "HEAD /users/me HTTP/1.1\r\nHost: a\r\n\r\n"), "Content-Length: 4\r\n")
+
+	// Test: Paths with only a POST route do not allow HEAD
+	post := NewRouter()
+	post.Post("/submit", named("post"))
+	assert.Contains(t, dispatch(t, post.Dispatch, "HEAD /submit HTTP/1.1\r\nHost: a\r\n\r\n"), "Allow: POST\r\n")
+
+	// Test: A custom NotFound handler
+	rt.NotFound = named("custom")
+	assert.Contains(t, dispatch(t, rt.Dispatch, "GET /nowhere HTTP/1.1\r\nHost: a\r\n\r\n"), "custom")
+}
+
+func TestRouterHeadOverConnection(t *testing.T) {
+	// Test: The GET route answers HEAD without its body, and the
+	// connection stays usable
+	rt := NewRouter()
+	rt.Get("/", okHandler)
+	s := newTestServer(t, rt.Dispatch, ServerConfig{})
+	conn := dial(t, s)
+	_, err := conn.Write([]byte("HEAD / HTTP/1.1\r\nHost: a\r\n\r\nGET / HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nContent-Type: text/plain\r\n\r\n"+
+		"HTTP/1.1 200 OK\r\nContent-Length: 2\r\nContent-Type: text/plain\r\nConnection: close\r\n\r\nok", string(out))
+}
@@ -1,66 +1,498 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net"
+	"runtime/debug"
 	"strconv"
+	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/iahta/httpfromtcp/internal/headers"
 	"github.com/iahta/httpfromtcp/internal/request"
 	"github.com/iahta/httpfromtcp/internal/response"
 )
 
+const DefaultIdleTimeout = 60 * time.Second
+const shutdownPollInterval = 50 * time.Millisecond
+
+// Accept errors are retried after a delay doubling from minAcceptBackoff up
+// to maxAcceptBackoff, so running out of file descriptors does not spin.
+const minAcceptBackoff = 5 * time.Millisecond
+const maxAcceptBackoff = time.Second
+
+// rejectTimeout bounds answering a connection over MaxConns, and
+// maxRejectDrain how much of its request is read and thrown away.
+const rejectTimeout = time.Second
+const maxRejectDrain = 64 * 1024
+
+type ServerConfig struct {
+	// ReadHeaderTimeout is how long a client has to send the request line
+	// and headers once the request has started. Zero means ReadTimeout.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout is how long a client has to send the whole request,
+	// body included. Zero means no timeout.
+	ReadTimeout time.Duration
+	// WriteTimeout is how long the handler has to write the response once
+	// the request has been read. Zero means no timeout.
+	WriteTimeout time.Duration
+	// IdleTimeout is how long a connection may wait for its first or next
+	// request before it is closed. Zero means DefaultIdleTimeout.
+	IdleTimeout time.Duration
+	// MaxRequestsPerConn caps how many requests are served on a single
+	// connection before it is closed. Zero means no limit.
+	MaxRequestsPerConn int
+	// StreamRequestBody leaves request bodies on the connection for handlers
+	// to read through Request.BodyReader instead of buffering them into
+	// Request.Body before the handler runs.
+	StreamRequestBody bool
+	// Limits bounds request line, header and body sizes. Zero fields use
+	// request.DefaultLimits.
+	Limits request.Limits
+	// ObsFold decides whether request header lines using obsolete line
+	// folding are rejected with 400, the default, or unfolded.
+	ObsFold headers.ObsFoldPolicy
+	// HeaderOrder sets the order response header fields are written in.
+	HeaderOrder response.HeaderOrder
+	// PreserveHeaderCase writes header names as handlers spelled them
+	// instead of canonicalizing them.
+	PreserveHeaderCase bool
+	// CheckContinue is called for requests sent with "Expect: 100-continue"
+	// before the client is told to go ahead with the body. Returning a
+	// *HandlerError, typically 417 or 413, rejects the request with it; any
+	// other error rejects it with 417. Nil accepts every request, though a
+	// Content-Length over Limits.MaxBodySize is always rejected with 413.
+	CheckContinue func(req *request.Request) error
+	// MaxConns caps how many connections are served at once. Zero means no
+	// limit. Connections over the limit wait in the listen queue until one
+	// finishes, unless RejectOverLimit is set.
+	MaxConns int
+	// RejectOverLimit accepts connections over MaxConns only to answer 503
+	// and close them, so clients fail fast instead of waiting.
+	RejectOverLimit bool
+	// AccessLog, if set, records every request served, including ones
+	// rejected as malformed.
+	AccessLog *AccessLog
+	// Metrics, if set, counts requests, bytes, connections and parse errors.
+	// Serve Metrics.Handler on a route to expose them.
+	Metrics *Metrics
+	// ErrorPages renders the responses the server sends itself, to malformed
+	// requests, connections over MaxConns and panicking handlers. Nil means
+	// DefaultErrorPages. Set the same pages on Router.ErrorPages and
+	// VirtualHosts.ErrorPages, pass them to Recover, and see
+	// Server.HandleErrors, to use them throughout.
+	ErrorPages *ErrorPages
+}
+
 type Server struct {
 	Listener net.Listener
 	Handler  Handler
 	Closed   atomic.Bool
+	Config   ServerConfig
+
+	mu    sync.Mutex
+	conns map[net.Conn]connState
+	// slots holds a token per connection being served when MaxConns is set.
+	slots chan struct{}
 }
 
+type connState int
+
+const (
+	connStateActive connState = iota
+	connStateIdle
+)
+
 func Serve(port int, h Handler) (*Server, error) {
+	return ServeWithConfig(port, h, ServerConfig{})
+}
+
+func ServeWithConfig(port int, h Handler, cfg ServerConfig) (*Server, error) {
 	l, err := net.Listen("tcp", ":"+strconv.Itoa(port))
 	if err != nil {
 		return nil, fmt.Errorf("unable to serve listener: %v", err)
 	}
+	return NewServer(l, h, cfg), nil
+}
+
+// NewServer starts serving h on l, which can be bound to any address, be a
+// Unix socket, or come from socket activation, see SystemdListeners. The
+// server takes ownership of l and closes it on Close or Shutdown.
+func NewServer(l net.Listener, h Handler, cfg ServerConfig) *Server {
+	if cfg.IdleTimeout == 0 {
+		cfg.IdleTimeout = DefaultIdleTimeout
+	}
 	server := &Server{
 		Listener: l,
 		Handler:  h,
+		Config:   cfg,
+		conns:    map[net.Conn]connState{},
+	}
+	if cfg.MaxConns > 0 {
+		server.slots = make(chan struct{}, cfg.MaxConns)
 	}
 
 	go server.listen()
-	return server, nil
+	return server
+}
+
+// Addr returns the address the server is listening on, which tells callers
+// the port picked for a ":0" listener.
+func (s *Server) Addr() net.Addr {
+	return s.Listener.Addr()
 }
 
+// Close stops accepting and closes every connection immediately, cutting
+// off any response in progress. Use Shutdown to let them finish.
 func (s *Server) Close() error {
 	s.Closed.Store(true)
+	var err error
 	if s.Listener != nil {
-		return s.Listener.Close()
+		err = s.Listener.Close()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return err
+}
+
+// Shutdown stops accepting, closes idle keep-alive connections and waits for
+// in-flight requests to finish, each then closing its connection. If ctx
+// expires first the remaining connections are closed and ctx.Err returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.Closed.Store(true)
+	var err error
+	if s.Listener != nil {
+		err = s.Listener.Close()
+	}
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeIdleConns() {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
 	}
-	return nil
+}
+
+// closeIdleConns closes connections waiting for their next request and
+// reports whether no connections are left.
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn, state := range s.conns {
+		if state == connStateIdle {
+			conn.Close()
+		}
+	}
+	return len(s.conns) == 0
+}
+
+func (s *Server) setConnState(conn net.Conn, state connState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns[conn] = state
+}
+
+func (s *Server) forgetConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
 }
 
 func (s *Server) listen() {
+	var backoff time.Duration
 	for {
+		if s.slots != nil && !s.Config.RejectOverLimit {
+			s.slots <- struct{}{}
+		}
 		conn, err := s.Listener.Accept()
 		if err != nil {
-			if s.Closed.Load() {
-				break
+			if s.slots != nil && !s.Config.RejectOverLimit {
+				<-s.slots
+			}
+			if s.Closed.Load() || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			backoff = min(max(2*backoff, minAcceptBackoff), maxAcceptBackoff)
+			log.Printf("error accepting connection: %v; retrying in %v", err, backoff)
+			time.Sleep(backoff)
+			continue
+		}
+		backoff = 0
+		if s.slots != nil && s.Config.RejectOverLimit {
+			select {
+			case s.slots <- struct{}{}:
+			default:
+				go s.reject(conn)
+				continue
 			}
-			fmt.Printf("error making connection: %v", err)
 		}
-		go s.handle(conn)
+		s.setConnState(conn, connStateIdle)
+		go func() {
+			defer s.releaseSlot()
+			if s.Config.Metrics != nil {
+				s.Config.Metrics.activeConns.Add(1)
+				defer s.Config.Metrics.activeConns.Add(-1)
+			}
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *Server) releaseSlot() {
+	if s.slots != nil {
+		<-s.slots
+	}
+}
+
+// reject answers a connection over MaxConns with 503 and closes it. The
+// unread request is drained first where possible, since closing with data
+// pending resets the connection and can destroy the response in flight.
+func (s *Server) reject(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(rejectTimeout))
+	s.Config.ErrorPages.orDefault().Write(s.newWriter(conn), nil, &HandlerError{
+		StatusCode: response.StatusServiceUnavailable,
+		Message:    "Too many connections",
+	})
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok && cw.CloseWrite() == nil {
+		io.Copy(io.Discard, io.LimitReader(conn, maxRejectDrain))
 	}
 }
 
 func (s *Server) handle(conn net.Conn) {
+	defer s.forgetConn(conn)
 	defer conn.Close()
-	w := response.NewWriter(conn)
-	req, err := request.RequestFromReader(conn)
-	if err != nil {
-		w.WriteStatusLine(response.StatusBadRequest)
-		body := []byte(fmt.Sprintf("Error parsing request: %v", err))
-		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
-		w.WriteBody(body)
+	var tlsState *tls.ConnectionState
+	if tc, ok := conn.(*tls.Conn); ok {
+		// Without a header timeout, IdleTimeout still bounds a client that
+		// never finishes its handshake.
+		timeout := s.headerTimeout()
+		if timeout == 0 {
+			timeout = s.Config.IdleTimeout
+		}
+		tc.SetDeadline(deadline(time.Now(), timeout))
+		if err := tc.Handshake(); err != nil {
+			return
+		}
+		state := tc.ConnectionState()
+		tlsState = &state
+	}
+	var rw io.ReadWriter = conn
+	if s.Config.Metrics != nil {
+		rw = s.Config.Metrics.countBytes(conn)
+	}
+	reader := request.NewReaderWithLimits(rw, s.Config.Limits)
+	reader.SetObsFoldPolicy(s.Config.ObsFold)
+	for served := 1; ; served++ {
+		// Until the first byte of a request arrives, including the first
+		// request, nothing is in flight and Shutdown may close the
+		// connection.
+		s.setConnState(conn, connStateIdle)
+		if s.Closed.Load() {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(s.Config.IdleTimeout))
+		if err := reader.WaitForRequest(); err != nil {
+			return
+		}
+		s.setConnState(conn, connStateActive)
+		start := time.Now()
+		w := s.newWriter(rw)
+		req, err := s.readRequest(conn, reader, w, start)
+		if req == nil {
+			var netErr net.Error
+			if errors.Is(err, io.EOF) || errors.As(err, &netErr) && !netErr.Timeout() {
+				return
+			}
+			if s.Config.Metrics != nil {
+				s.Config.Metrics.observeParseError(err)
+			}
+			conn.SetWriteDeadline(deadline(time.Now(), s.Config.WriteTimeout))
+			s.Config.ErrorPages.orDefault().Write(w, nil, &HandlerError{
+				StatusCode: statusForParseError(err),
+				Message:    fmt.Sprintf("Error parsing request: %v", err),
+			})
+			s.logAccess(conn, w, nil, start)
+			return
+		}
+
+		req.TLS = tlsState
+		conn.SetWriteDeadline(deadline(time.Now(), s.Config.WriteTimeout))
+		w.SetHTTPVersion(req.RequestLine.HttpVersion)
+		w.SetRequestMethod(req.RequestLine.Method)
+		lastRequest := s.Config.MaxRequestsPerConn > 0 && served >= s.Config.MaxRequestsPerConn
+		w.SetKeepAlive(req.KeepAlive() && !lastRequest && !s.Closed.Load())
+		var herr *HandlerError
+		if errors.As(err, &herr) {
+			// The client was told not to send the body, so the connection
+			// cannot carry another request.
+			w.SetKeepAlive(false)
+			s.Config.ErrorPages.orDefault().Write(w, req, herr)
+		} else {
+			s.serve(w, req)
+		}
+		s.logAccess(conn, w, req, start)
+		if s.Config.Metrics != nil {
+			s.Config.Metrics.observeRequest(req.RequestLine.Method, int(w.StatusCode()), time.Since(start))
+		}
+		// A client still waiting for 100 Continue was answered without it
+		// and may or may not send the body, so the connection cannot be
+		// trusted to carry another request.
+		if !w.KeepAlive() || req.ExpectsContinue() {
+			return
+		}
+		if err := req.BodyReader().Close(); err != nil {
+			return
+		}
+	}
+}
+
+// HandleErrors adapts h to a Handler that renders its errors with the
+// server's ErrorPages, like the package-level HandleErrors does with
+// DefaultErrorPages.
+func (s *Server) HandleErrors(h ErrorHandler) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		s.Config.ErrorPages.orDefault().HandleErrors(h)(w, req)
+	}
+}
+
+// logAccess records the response w gave req in the access log, if there is
+// one. req is nil if the request could not be parsed.
+func (s *Server) logAccess(conn net.Conn, w *response.Writer, req *request.Request, start time.Time) {
+	if s.Config.AccessLog == nil {
 		return
 	}
+	entry := AccessLogEntry{
+		Time:       start,
+		RemoteAddr: conn.RemoteAddr().String(),
+		Status:     int(w.StatusCode()),
+		Bytes:      w.BytesWritten(),
+		Duration:   time.Since(start),
+	}
+	if req != nil {
+		entry.Method = req.RequestLine.Method
+		entry.Target = req.RequestLine.RequestTarget
+		entry.Proto = "HTTP/" + req.RequestLine.HttpVersion
+		entry.Referer, _ = req.Headers.Get("Referer")
+		entry.UserAgent, _ = req.Headers.Get("User-Agent")
+	}
+	s.Config.AccessLog.Log(entry)
+}
+
+func (s *Server) newWriter(conn io.Writer) *response.Writer {
+	w := response.NewWriter(conn)
+	w.SetHeaderOrder(s.Config.HeaderOrder)
+	w.SetPreserveHeaderCase(s.Config.PreserveHeaderCase)
+	return w
+}
+
+// serve runs the handler, recovering a panic so it only costs the client its
+// connection rather than taking the whole process down.
+func (s *Server) serve(w *response.Writer, req *request.Request) {
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		log.Printf("panic serving %s %s: %v\n%s", req.RequestLine.Method, req.RequestLine.RequestTarget, v, debug.Stack())
+		s.Config.ErrorPages.orDefault().writePanicResponse(w, req)
+	}()
 	s.Handler(w, req)
 }
+
+// writePanicResponse answers 500 if the panicking handler had not started its
+// response. Either way the connection is closed afterwards, since the handler
+// may have left the request or response half done.
+func (p *ErrorPages) writePanicResponse(w *response.Writer, req *request.Request) {
+	w.SetKeepAlive(false)
+	if w.State() != response.WriterStatusCode {
+		return
+	}
+	p.Write(w, req, &HandlerError{StatusCode: response.StatusInternalServerError})
+}
+
+// readRequest reads the request line and headers under ReadHeaderTimeout and,
+// unless bodies are streamed, the body under ReadTimeout. Both are measured
+// from start. A streamed body stays under ReadTimeout while the handler runs.
+// If the client expects 100 Continue, it is written to w just before the body
+// is first read, once CheckContinue has accepted the request. A request
+// CheckContinue rejects is returned along with the *HandlerError to answer
+// it with; on any other error the request is nil.
+func (s *Server) readRequest(conn net.Conn, reader *request.Reader, w *response.Writer, start time.Time) (*request.Request, error) {
+	conn.SetReadDeadline(deadline(start, s.headerTimeout()))
+	req, err := reader.ReadRequestHeaders()
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(deadline(start, s.Config.ReadTimeout))
+	if req.ExpectsContinue() {
+		if err := s.checkContinue(req); err != nil {
+			return req, err
+		}
+		conn.SetWriteDeadline(deadline(start, s.Config.ReadTimeout))
+		req.SetContinueFunc(w.WriteContinue)
+	}
+	if s.Config.StreamRequestBody {
+		return req, nil
+	}
+	if err := req.ReadBody(); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// headerTimeout is how long a client has for the request line and headers,
+// and for the TLS handshake.
+func (s *Server) headerTimeout() time.Duration {
+	if s.Config.ReadHeaderTimeout == 0 {
+		return s.Config.ReadTimeout
+	}
+	return s.Config.ReadHeaderTimeout
+}
+
+func (s *Server) checkContinue(req *request.Request) error {
+	if s.Config.CheckContinue == nil {
+		return nil
+	}
+	err := s.Config.CheckContinue(req)
+	if err == nil {
+		return nil
+	}
+	var herr *HandlerError
+	if errors.As(err, &herr) {
+		return herr
+	}
+	return &HandlerError{StatusCode: response.StatusExpectationFailed, Message: err.Error()}
+}
+
+// deadline returns the time timeout after start, or no deadline at all if
+// timeout is not positive.
+func deadline(start time.Time, timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return start.Add(timeout)
+}
+
+func statusForParseError(err error) response.StatusCode {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return response.StatusRequestTimeout
+	}
+	return response.StatusCode(request.StatusCode(err))
+}
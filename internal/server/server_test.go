@@ -0,0 +1,314 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iahta/httpfromtcp/internal/headers"
+	"github.com/iahta/httpfromtcp/internal/request"
+	"github.com/iahta/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestServer serves h on an ephemeral port and closes it when the test
+// ends.
+func newTestServer(t *testing.T, h Handler, cfg ServerConfig) *Server {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := NewServer(l, h, cfg)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func dial(t *testing.T, s *Server) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func okHandler(w *response.Writer, req *request.Request) {
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeaders(2))
+	w.WriteBody([]byte("ok"))
+}
+
+func TestShutdownClosesSilentConnections(t *testing.T) {
+	// Test: A connection that never sent a byte does not hold up Shutdown
+	s := newTestServer(t, okHandler, ServerConfig{})
+	dial(t, s)
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	require.NoError(t, s.Shutdown(ctx))
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestExpectContinueAfterResponseStarted(t *testing.T) {
+	// Test: A streaming handler that writes its headers before reading the
+	// body gets the body without an interim 100
+	echo := func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		h := headers.NewHeaders()
+		h.Add("Transfer-Encoding", "chunked")
+		w.WriteHeaders(h)
+		body, err := io.ReadAll(req.BodyReader())
+		if err != nil {
+			body = []byte("error: " + err.Error())
+		}
+		w.WriteChunkedBody(body)
+		w.WriteChunkedBodyDone()
+		w.WriteTrailers(nil)
+	}
+	s := newTestServer(t, echo, ServerConfig{StreamRequestBody: true})
+	conn := dial(t, s)
+	_, err := conn.Write([]byte("POST / HTTP/1.1\r\nHost: a\r\nExpect: 100-continue\r\nContent-Length: 5\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+
+	br := bufio.NewReader(conn)
+	status, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", status)
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	rest, err := io.ReadAll(br)
+	require.NoError(t, err)
+	assert.Equal(t, "Transfer-Encoding: chunked\r\nConnection: close\r\n\r\n5\r\nhello\r\n0\r\n\r\n", string(rest))
+}
+
+func TestExpectContinue(t *testing.T) {
+	echo := func(w *response.Writer, req *request.Request) {
+		body, _ := io.ReadAll(req.BodyReader())
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}
+	for _, stream := range []bool{false, true} {
+		// Test: The client is told to continue before the body is read
+		s := newTestServer(t, echo, ServerConfig{StreamRequestBody: stream})
+		conn := dial(t, s)
+		_, err := conn.Write([]byte("POST / HTTP/1.1\r\nHost: a\r\nExpect: 100-continue\r\nContent-Length: 5\r\nConnection: close\r\n\r\n"))
+		require.NoError(t, err)
+		br := bufio.NewReader(conn)
+		interim := make([]byte, len("HTTP/1.1 100 Continue\r\n\r\n"))
+		_, err = io.ReadFull(br, interim)
+		require.NoError(t, err)
+		assert.Equal(t, "HTTP/1.1 100 Continue\r\n\r\n", string(interim))
+		_, err = conn.Write([]byte("hello"))
+		require.NoError(t, err)
+		rest, err := io.ReadAll(br)
+		require.NoError(t, err)
+		assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nContent-Type: text/plain\r\nConnection: close\r\n\r\nhello", string(rest))
+	}
+}
+
+func TestKeepAliveAfterNoContent(t *testing.T) {
+	// Test: A 204 keeps the connection open for the next request
+	noContent := func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusNoContent)
+		w.WriteHeaders(headers.NewHeaders())
+	}
+	s := newTestServer(t, noContent, ServerConfig{})
+	conn := dial(t, s)
+	_, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\n\r\nGET / HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\n\r\nHTTP/1.1 204 No Content\r\nConnection: close\r\n\r\n", string(out))
+}
+
+func TestKeepAlivePipelined(t *testing.T) {
+	// Test: Pipelined requests are answered in order on one connection
+	s := newTestServer(t, okHandler, ServerConfig{})
+	conn := dial(t, s)
+	_, err := conn.Write([]byte("GET /1 HTTP/1.1\r\nHost: a\r\n\r\n" +
+		"POST /2 HTTP/1.1\r\nHost: a\r\nContent-Length: 3\r\n\r\nabc" +
+		"GET /3 HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	ok := "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nContent-Type: text/plain\r\n"
+	assert.Equal(t, ok+"\r\nok"+ok+"\r\nok"+ok+"Connection: close\r\n\r\nok", string(out))
+}
+
+func TestKeepAliveMaxRequestsPerConn(t *testing.T) {
+	// Test: The last allowed request is answered with Connection: close
+	s := newTestServer(t, okHandler, ServerConfig{MaxRequestsPerConn: 1})
+	conn := dial(t, s)
+	_, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+	require.NoError(t, err)
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nContent-Type: text/plain\r\nConnection: close\r\n\r\nok", string(out))
+}
+
+func TestShutdownWaitsForInFlightRequest(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	slow := func(w *response.Writer, req *request.Request) {
+		close(entered)
+		<-release
+		okHandler(w, req)
+	}
+	s := newTestServer(t, slow, ServerConfig{})
+	conn := dial(t, s)
+	_, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+	require.NoError(t, err)
+	<-entered
+
+	done := make(chan error, 1)
+	go func() { done <- s.Shutdown(context.Background()) }()
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned %v with a request in flight", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Test: The response is delivered, then the connection closed
+	close(release)
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "HTTP/1.1 200 OK\r\n")
+	assert.True(t, strings.HasSuffix(string(out), "\r\n\r\nok"))
+	require.NoError(t, <-done)
+
+	// Test: No new connections are accepted
+	_, err = net.Dial("tcp", s.Addr().String())
+	assert.Error(t, err)
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	// Test: A handler that outlives ctx has its connection closed
+	release := make(chan struct{})
+	defer close(release)
+	s := newTestServer(t, func(w *response.Writer, req *request.Request) { <-release }, ServerConfig{})
+	conn := dial(t, s)
+	_, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+	_, err = io.ReadAll(conn)
+	require.NoError(t, err)
+}
+
+func TestReadHeaderTimeout(t *testing.T) {
+	// Test: A request whose headers stall is answered 408 and closed
+	s := newTestServer(t, okHandler, ServerConfig{ReadHeaderTimeout: 50 * time.Millisecond})
+	conn := dial(t, s)
+	_, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\n"))
+	require.NoError(t, err)
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 408 Request Timeout\r\n"), string(out))
+}
+
+func TestIdleTimeout(t *testing.T) {
+	// Test: A connection that sends nothing is closed without a response
+	s := newTestServer(t, okHandler, ServerConfig{IdleTimeout: 50 * time.Millisecond})
+	conn := dial(t, s)
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Empty(t, out)
+}
+
+func TestMaxConnsRejectOverLimit(t *testing.T) {
+	s := newTestServer(t, okHandler, ServerConfig{MaxConns: 1, RejectOverLimit: true})
+	first := dial(t, s)
+	time.Sleep(20 * time.Millisecond)
+
+	// Test: A connection over the limit is answered 503
+	second := dial(t, s)
+	_, err := second.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+	require.NoError(t, err)
+	out, err := io.ReadAll(second)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 503 Service Unavailable\r\n"), string(out))
+
+	// Test: The connection holding the slot is still served
+	_, err = first.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	out, err = io.ReadAll(first)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(string(out), "\r\n\r\nok"), string(out))
+}
+
+func TestMaxConnsQueues(t *testing.T) {
+	s := newTestServer(t, okHandler, ServerConfig{MaxConns: 1})
+	first := dial(t, s)
+	time.Sleep(20 * time.Millisecond)
+
+	// Test: A connection over the limit waits for a free slot
+	second := dial(t, s)
+	_, err := second.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	second.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err = second.Read(make([]byte, 1))
+	var netErr net.Error
+	require.ErrorAs(t, err, &netErr)
+	assert.True(t, netErr.Timeout())
+
+	first.Close()
+	second.SetReadDeadline(time.Now().Add(5 * time.Second))
+	out, err := io.ReadAll(second)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(string(out), "\r\n\r\nok"), string(out))
+}
+
+func TestCheckContinueRejection(t *testing.T) {
+	var logs bytes.Buffer
+	metrics := NewMetrics()
+	s := newTestServer(t, okHandler, ServerConfig{
+		CheckContinue: func(req *request.Request) error {
+			if req.RequestLine.Target.RawPath == "/big" {
+				return &HandlerError{StatusCode: response.StatusContentTooLarge, Message: "too big"}
+			}
+			return errors.New("no")
+		},
+		AccessLog: NewAccessLog(&logs, AccessLogCommon),
+		Metrics:   metrics,
+	})
+	tests := []struct {
+		target string
+		want   string
+	}{
+		// Test: A *HandlerError is answered as is, honouring Accept
+		{"/big", "HTTP/1.1 413 Content Too Large\r\nContent-Length: 63\r\nContent-Type: application/json\r\nConnection: close\r\n\r\n" +
+			`{"status":413,"error":"Content Too Large","message":"too big"}` + "\n"},
+		// Test: Any other error is answered 417
+		{"/other", "HTTP/1.1 417 Expectation Failed\r\n"},
+	}
+	for _, tt := range tests {
+		conn := dial(t, s)
+		_, err := conn.Write([]byte("POST " + tt.target + " HTTP/1.1\r\nHost: a\r\nAccept: application/json\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n"))
+		require.NoError(t, err)
+		out, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(out), tt.want), string(out))
+	}
+
+	// Test: Rejections are logged and counted as requests, not parse errors
+	require.NoError(t, s.Shutdown(context.Background()))
+	assert.Contains(t, logs.String(), `"POST /big HTTP/1.1" 413 `)
+	assert.Contains(t, logs.String(), `"POST /other HTTP/1.1" 417 `)
+	var buf bytes.Buffer
+	metrics.WriteTo(&buf)
+	assert.Contains(t, buf.String(), `http_server_requests_total{method="POST",status="413"} 1`)
+	assert.Contains(t, buf.String(), `http_server_requests_total{method="POST",status="417"} 1`)
+	assert.NotContains(t, buf.String(), "http_server_parse_errors_total{")
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func assertActivationEnvCleared(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		_, ok := os.LookupEnv(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func TestSystemdListenersNotActivated(t *testing.T) {
+	tests := []struct {
+		name string
+		pid  string
+	}{
+		{"no LISTEN_PID", ""},
+		{"invalid LISTEN_PID", "abc"},
+		// Test: Variables meant for another process, e.g. a parent that
+		// did not clear them
+		{"other process", strconv.Itoa(os.Getpid() + 1)},
+	}
+	for _, tt := range tests {
+		t.Setenv("LISTEN_PID", tt.pid)
+		t.Setenv("LISTEN_FDS", "1")
+		t.Setenv("LISTEN_FDNAMES", "http")
+		listeners, err := SystemdListeners()
+		require.NoError(t, err, tt.name)
+		assert.Empty(t, listeners, tt.name)
+		assertActivationEnvCleared(t)
+	}
+}
+
+func TestSystemdListenersInvalidFds(t *testing.T) {
+	for _, fds := range []string{"", "x", "-1", "1.5"} {
+		t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+		t.Setenv("LISTEN_FDS", fds)
+		listeners, err := SystemdListeners()
+		assert.Error(t, err, "LISTEN_FDS=%q", fds)
+		assert.Nil(t, listeners)
+		assertActivationEnvCleared(t)
+	}
+
+	// Test: Activated with no sockets
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "0")
+	listeners, err := SystemdListeners()
+	require.NoError(t, err)
+	assert.Empty(t, listeners)
+	assertActivationEnvCleared(t)
+}
+
+func TestNewServerUnixSocket(t *testing.T) {
+	var logs strings.Builder
+	path := filepath.Join(t.TempDir(), "http.sock")
+	l, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	s := NewServer(l, okHandler, ServerConfig{AccessLog: NewAccessLog(&logs, AccessLogCommon)})
+	t.Cleanup(func() { s.Close() })
+	assert.Equal(t, path, s.Addr().String())
+
+	// Test: Requests are served over the socket
+	conn, err := net.Dial("unix", path)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nContent-Type: text/plain\r\nConnection: close\r\n\r\nok", string(out))
+
+	// Test: Clients without an address are logged as "-"
+	require.NoError(t, s.Shutdown(t.Context()))
+	assert.True(t, strings.HasPrefix(logs.String(), "- - - ["), logs.String())
+
+	// Test: Shutdown closes the listener, removing the socket file
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
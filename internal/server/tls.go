@@ -0,0 +1,161 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// certCheckInterval is how often CertStore looks at its files for changes
+// while serving handshakes.
+const certCheckInterval = 10 * time.Second
+
+// ServeTLS is Serve over TLS with the certificate and key in certFile and
+// keyFile, which are reloaded when they change on disk.
+func ServeTLS(port int, h Handler, certFile, keyFile string) (*Server, error) {
+	certs := NewCertStore()
+	if err := certs.Load(certFile, keyFile); err != nil {
+		return nil, err
+	}
+	return ServeTLSWithConfig(port, h, &tls.Config{GetCertificate: certs.GetCertificate}, ServerConfig{})
+}
+
+// ServeTLSWithConfig is ServeWithConfig over TLS. tlsConfig must provide
+// certificates, through Certificates or GetCertificate, and may ask for
+// client certificates for mutual TLS. TLS 1.2 is the minimum version unless
+// tlsConfig sets one.
+func ServeTLSWithConfig(port int, h Handler, tlsConfig *tls.Config, cfg ServerConfig) (*Server, error) {
+	if tlsConfig == nil || len(tlsConfig.Certificates) == 0 && tlsConfig.GetCertificate == nil && tlsConfig.GetConfigForClient == nil {
+		return nil, errors.New("unable to serve TLS: no certificate configured")
+	}
+	tlsConfig = tlsConfig.Clone()
+	if tlsConfig.MinVersion == 0 {
+		tlsConfig.MinVersion = tls.VersionTLS12
+	}
+	if len(tlsConfig.NextProtos) == 0 {
+		tlsConfig.NextProtos = []string{"http/1.1"}
+	}
+	l, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		return nil, fmt.Errorf("unable to serve listener: %v", err)
+	}
+	return NewServer(tls.NewListener(l, tlsConfig), h, cfg), nil
+}
+
+// CertStore holds certificate and key pairs loaded from disk and hands them
+// out during handshakes through GetCertificate. With several pairs loaded
+// it picks the first one valid for the server name the client asked for via
+// SNI, falling back to the first pair. Files are checked for changes every
+// certCheckInterval, so renewed certificates are picked up without a
+// restart; a pair that fails to reload keeps serving its old certificate.
+type CertStore struct {
+	mu        sync.RWMutex
+	pairs     []*certPair
+	lastCheck time.Time
+}
+
+type certPair struct {
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+	modTime  time.Time
+}
+
+func NewCertStore() *CertStore {
+	return &CertStore{}
+}
+
+// Load reads a certificate and key pair and adds it to the store.
+func (s *CertStore) Load(certFile, keyFile string) error {
+	p := &certPair{certFile: certFile, keyFile: keyFile}
+	if err := p.load(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pairs = append(s.pairs, p)
+	return nil
+}
+
+// Reload rereads every pair whose files changed since they were loaded. It
+// returns the errors of the pairs that could not be reloaded.
+func (s *CertStore) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastCheck = time.Now()
+	var errs []error
+	for _, p := range s.pairs {
+		modTime, err := p.latestModTime()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if modTime.Equal(p.modTime) {
+			continue
+		}
+		if err := p.load(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// GetCertificate is meant for tls.Config.GetCertificate.
+func (s *CertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	stale := time.Since(s.lastCheck) >= certCheckInterval
+	s.mu.RUnlock()
+	if stale {
+		if err := s.Reload(); err != nil {
+			log.Printf("error reloading certificates: %v", err)
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.pairs) == 0 {
+		return nil, errors.New("no certificates loaded")
+	}
+	for _, p := range s.pairs {
+		if hello.SupportsCertificate(p.cert) == nil {
+			return p.cert, nil
+		}
+	}
+	return s.pairs[0].cert, nil
+}
+
+func (p *certPair) load() error {
+	modTime, err := p.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(p.certFile, p.keyFile)
+	if err != nil {
+		return fmt.Errorf("unable to load certificate %s: %v", p.certFile, err)
+	}
+	p.cert = &cert
+	p.modTime = modTime
+	return nil
+}
+
+// latestModTime returns the later of the modification times of the pair's
+// two files.
+func (p *certPair) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{p.certFile, p.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unable to stat certificate file: %v", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
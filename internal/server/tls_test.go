@@ -0,0 +1,173 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/iahta/httpfromtcp/internal/request"
+	"github.com/iahta/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCert writes a self-signed certificate for host, and its key, to dir
+// as host.crt and host.key. The files' modification time is set to modTime
+// so rewrites within the same second are still seen as changes.
+func writeCert(t *testing.T, dir, host string, modTime time.Time) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile = filepath.Join(dir, host+".crt")
+	keyFile = filepath.Join(dir, host+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	require.NoError(t, os.Chtimes(certFile, modTime, modTime))
+	require.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+	return certFile, keyFile
+}
+
+// peerCert completes a handshake with s for serverName and returns the
+// certificate the server presented.
+func peerCert(t *testing.T, s *Server, serverName string) *x509.Certificate {
+	t.Helper()
+	conn, err := tls.Dial("tcp", s.Addr().String(), &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	require.NoError(t, err)
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0]
+}
+
+func TestServeTLS(t *testing.T) {
+	certFile, keyFile := writeCert(t, t.TempDir(), "example.test", time.Now())
+	h := func(w *response.Writer, req *request.Request) {
+		body := "plain"
+		if req.TLS != nil {
+			body = req.TLS.ServerName + " " + tls.VersionName(req.TLS.Version) + " " + req.TLS.NegotiatedProtocol
+		}
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	}
+	s, err := ServeTLS(0, h, certFile, keyFile)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	// Test: The handshake succeeds and req.TLS describes it
+	conn, err := tls.Dial("tcp", s.Addr().String(), &tls.Config{
+		ServerName:         "example.test",
+		InsecureSkipVerify: true,
+		MaxVersion:         tls.VersionTLS12,
+		NextProtos:         []string{"http/1.1"},
+	})
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, "example.test", conn.ConnectionState().PeerCertificates[0].Subject.CommonName)
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.test\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "\r\n\r\nexample.test TLS 1.2 http/1.1")
+
+	// Test: TLS 1.2 is the minimum version
+	_, err = tls.Dial("tcp", s.Addr().String(), &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS11})
+	assert.Error(t, err)
+}
+
+func TestServeTLSWithoutCertificate(t *testing.T) {
+	_, err := ServeTLSWithConfig(0, okHandler, &tls.Config{}, ServerConfig{})
+	assert.Error(t, err)
+	_, err = ServeTLS(0, okHandler, "missing.crt", "missing.key")
+	assert.Error(t, err)
+}
+
+func TestServeTLSHandshakeTimeout(t *testing.T) {
+	// Test: A client that never sends a ClientHello is dropped after
+	// IdleTimeout when no read timeouts are set
+	certFile, keyFile := writeCert(t, t.TempDir(), "example.test", time.Now())
+	certs := NewCertStore()
+	require.NoError(t, certs.Load(certFile, keyFile))
+	s, err := ServeTLSWithConfig(0, okHandler, &tls.Config{GetCertificate: certs.GetCertificate}, ServerConfig{IdleTimeout: 100 * time.Millisecond})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	_, err = io.ReadAll(conn)
+	assert.NoError(t, err)
+}
+
+func TestCertStoreSNI(t *testing.T) {
+	dir := t.TempDir()
+	certs := NewCertStore()
+	for _, host := range []string{"a.test", "b.test"} {
+		require.NoError(t, certs.Load(writeCert(t, dir, host, time.Now())))
+	}
+	s, err := ServeTLSWithConfig(0, okHandler, &tls.Config{GetCertificate: certs.GetCertificate}, ServerConfig{})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	// Test: The pair valid for the requested name is picked, falling back
+	// to the first one
+	assert.Equal(t, "a.test", peerCert(t, s, "a.test").Subject.CommonName)
+	assert.Equal(t, "b.test", peerCert(t, s, "b.test").Subject.CommonName)
+	assert.Equal(t, "a.test", peerCert(t, s, "c.test").Subject.CommonName)
+}
+
+func TestCertStoreReload(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Now().Add(-time.Minute)
+	certs := NewCertStore()
+	require.NoError(t, certs.Load(writeCert(t, dir, "a.test", modTime)))
+	s, err := ServeTLSWithConfig(0, okHandler, &tls.Config{GetCertificate: certs.GetCertificate}, ServerConfig{})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	first := peerCert(t, s, "a.test")
+
+	// Test: Unchanged files are not reloaded
+	require.NoError(t, certs.Reload())
+	assert.Equal(t, first.SerialNumber, peerCert(t, s, "a.test").SerialNumber)
+
+	// Test: Rewritten files are picked up once the check interval passes
+	certFile, _ := writeCert(t, dir, "a.test", modTime.Add(time.Second))
+	assert.Equal(t, first.SerialNumber, peerCert(t, s, "a.test").SerialNumber)
+	certs.mu.Lock()
+	certs.lastCheck = time.Now().Add(-certCheckInterval)
+	certs.mu.Unlock()
+	second := peerCert(t, s, "a.test")
+	assert.NotEqual(t, first.SerialNumber, second.SerialNumber)
+
+	// Test: A pair that fails to reload keeps its old certificate
+	require.NoError(t, os.WriteFile(certFile, []byte("not a certificate"), 0o600))
+	require.NoError(t, os.Chtimes(certFile, modTime.Add(2*time.Second), modTime.Add(2*time.Second)))
+	assert.Error(t, certs.Reload())
+	assert.Equal(t, second.SerialNumber, peerCert(t, s, "a.test").SerialNumber)
+}
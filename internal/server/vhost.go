@@ -0,0 +1,95 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/iahta/httpfromtcp/internal/request"
+	"github.com/iahta/httpfromtcp/internal/response"
+)
+
+// VirtualHosts dispatches requests to handlers registered by host name, so
+// one server can front several sites. Patterns are either an exact host,
+// "example.com", or a wildcard, "*.example.com", matching any subdomain at
+// any depth but not example.com itself. Exact hosts win over wildcards, and
+// longer wildcards over shorter ones. Ports are ignored and names compared
+// case-insensitively.
+type VirtualHosts struct {
+	hosts     map[string]Handler
+	wildcards map[string]Handler
+	// Default handles requests for hosts no pattern matches. Nil means a
+	// 421 page.
+	Default Handler
+	// ErrorPages renders the 421 page. Nil means DefaultErrorPages.
+	ErrorPages *ErrorPages
+}
+
+func NewVirtualHosts() *VirtualHosts {
+	return &VirtualHosts{
+		hosts:     map[string]Handler{},
+		wildcards: map[string]Handler{},
+	}
+}
+
+// Handle registers h for requests whose host matches pattern. It panics on a
+// malformed pattern, as that is a programming error.
+func (v *VirtualHosts) Handle(pattern string, h Handler) {
+	host := normalizeHost(pattern)
+	if suffix, ok := strings.CutPrefix(host, "*."); ok {
+		if suffix == "" || strings.Contains(suffix, "*") {
+			panic(fmt.Errorf("malformed host pattern: %q", pattern))
+		}
+		v.wildcards["."+suffix] = h
+		return
+	}
+	if host == "" || strings.Contains(host, "*") {
+		panic(fmt.Errorf("malformed host pattern: %q", pattern))
+	}
+	v.hosts[host] = h
+}
+
+// Dispatch is a Handler that runs the handler for the request's host.
+func (v *VirtualHosts) Dispatch(w *response.Writer, req *request.Request) {
+	if h := v.lookup(normalizeHost(req.Host())); h != nil {
+		h(w, req)
+		return
+	}
+	if v.Default != nil {
+		v.Default(w, req)
+		return
+	}
+	v.ErrorPages.orDefault().Write(w, req, &HandlerError{StatusCode: response.StatusMisdirectedRequest})
+}
+
+func (v *VirtualHosts) lookup(host string) Handler {
+	if host == "" {
+		return nil
+	}
+	if h, ok := v.hosts[host]; ok {
+		return h
+	}
+	// Try the longest suffix first: for a.b.example.com, .b.example.com
+	// then .example.com then .com.
+	for i := strings.IndexByte(host, '.'); i != -1; {
+		if h, ok := v.wildcards[host[i:]]; ok {
+			return h
+		}
+		next := strings.IndexByte(host[i+1:], '.')
+		if next == -1 {
+			break
+		}
+		i += next + 1
+	}
+	return nil
+}
+
+// normalizeHost lower-cases host and strips its port, the brackets around
+// an IPv6 literal, and a trailing dot.
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
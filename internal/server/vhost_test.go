@@ -0,0 +1,63 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVirtualHostsLookup(t *testing.T) {
+	v := NewVirtualHosts()
+	v.Handle("Example.com", named("exact"))
+	v.Handle("*.example.com", named("wildcard"))
+	v.Handle("*.api.example.com", named("api"))
+	v.Handle("[::1]", named("ipv6"))
+
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"example.com", "exact"},
+		{"EXAMPLE.COM:8080", "exact"},
+		{"example.com.", "exact"},
+		{"www.example.com", "wildcard"},
+		{"a.b.example.com", "wildcard"},
+		{"v1.api.example.com", "api"},
+		{"api.example.com", "wildcard"},
+		{"[::1]:8080", "ipv6"},
+		{"example.org", ""},
+		{"notexample.com", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		h := v.lookup(normalizeHost(tt.host))
+		if tt.want == "" {
+			assert.Nil(t, h, tt.host)
+			continue
+		}
+		if assert.NotNil(t, h, tt.host) {
+			assert.Contains(t, dispatch(t, h, "GET / HTTP/1.1\r\nHost: x\r\n\r\n"), tt.want, tt.host)
+		}
+	}
+}
+
+func TestVirtualHostsDispatch(t *testing.T) {
+	v := NewVirtualHosts()
+	v.Handle("a.test", named("a"))
+
+	// Test: Unknown hosts get 421 without a default
+	assert.Contains(t, dispatch(t, v.Dispatch, "GET / HTTP/1.1\r\nHost: b.test\r\n\r\n"), "421 Misdirected Request")
+
+	// Test: And the default handler with one
+	v.Default = named("default")
+	assert.Contains(t, dispatch(t, v.Dispatch, "GET / HTTP/1.1\r\nHost: b.test\r\n\r\n"), "default")
+
+	// Test: The authority of an absolute-form target wins over Host
+	assert.Contains(t, dispatch(t, v.Dispatch, "GET http://a.test/ HTTP/1.1\r\nHost: b.test\r\n\r\n"), "\r\n\r\na")
+}
+
+func TestVirtualHostsBadPatterns(t *testing.T) {
+	for _, pattern := range []string{"", "*.", "a.*.com", "*"} {
+		assert.Panics(t, func() { NewVirtualHosts().Handle(pattern, named("x")) }, pattern)
+	}
+}